@@ -0,0 +1,353 @@
+//帧压缩：长度字段最高位为压缩标记，压缩帧内容为1字节算法编号+压缩数据，解码时自动解压
+
+package handler
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"github.com/yhhaiua/engine/buffer"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultCompressThreshold = 1024
+
+var errCompressAlgorithm = errors.New("unknown compress algorithm")
+
+// Compressor 压缩算法
+type Compressor interface {
+	Id() byte //写入压缩帧的算法编号
+	Compress(dst, src []byte) ([]byte, error)
+	Decompress(src []byte, maxSize int) ([]byte, error)
+}
+
+var (
+	Deflate Compressor = &flateCompressor{id: 1} //deflate，压缩率高
+	Zlib    Compressor = &flateCompressor{id: 2} //zlib（deflate加校验）
+	Snappy  Compressor = snappyCompressor{}      //snappy块格式，速度快
+)
+
+// compressors 解码时按编号查找算法
+var compressors = map[byte]Compressor{
+	Deflate.Id(): Deflate,
+	Zlib.Id():    Zlib,
+	Snappy.Id():  Snappy,
+}
+
+type flateCompressor struct {
+	id      byte
+	writers sync.Pool
+}
+
+func (c *flateCompressor) Id() byte {
+	return c.id
+}
+
+// flateWriter 复用deflate/zlib的压缩状态（每个约600KB）
+type flateWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+func (c *flateCompressor) Compress(dst, src []byte) ([]byte, error) {
+	out := bytes.NewBuffer(dst)
+	w, _ := c.writers.Get().(flateWriter)
+	if w == nil {
+		if c.id == Zlib.Id() {
+			w = zlib.NewWriter(out)
+		} else {
+			w, _ = flate.NewWriter(out, flate.DefaultCompression)
+		}
+	} else {
+		w.Reset(out)
+	}
+	defer c.writers.Put(w)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func (c *flateCompressor) Decompress(src []byte, maxSize int) ([]byte, error) {
+	var r io.ReadCloser
+	if c.id == Zlib.Id() {
+		var err error
+		if r, err = zlib.NewReader(bytes.NewReader(src)); err != nil {
+			return nil, err
+		}
+	} else {
+		r = flate.NewReader(bytes.NewReader(src))
+	}
+	defer r.Close()
+	//多读1字节判断是否超过最大长度，防止压缩炸弹
+	out, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > maxSize {
+		return nil, errors.New("decompressed frame length is more than maxFrameLength: " + strconv.Itoa(maxSize))
+	}
+	return out, nil
+}
+
+type snappyCompressor struct{}
+
+func (snappyCompressor) Id() byte {
+	return 3
+}
+
+func (snappyCompressor) Compress(dst, src []byte) ([]byte, error) {
+	return snappyEncode(dst, src), nil
+}
+
+func (snappyCompressor) Decompress(src []byte, maxSize int) ([]byte, error) {
+	return snappyDecode(src, maxSize)
+}
+
+// Compression 帧压缩配置
+type Compression struct {
+	Algorithm      Compressor     //压缩算法，默认Deflate，解码支持所有内置算法
+	Threshold      int            //消息内容超过该字节数时压缩，0使用默认1024（不超过MaxFrameLength），不能为负数或超过MaxFrameLength
+	MaxFrameLength int            //解压后的最大长度，默认327670，需小于长度字段最高位（1字节时最大127，2字节时最大32767）
+	Stats          *CompressStats //按消息编号统计，为空不统计
+}
+
+// CompressCodeStats 单个消息编号的压缩统计
+type CompressCodeStats struct {
+	Frames          int64         //编码的帧数
+	Compressed      int64         //其中压缩的帧数
+	RawBytes        int64         //压缩前字节数（只统计压缩的帧）
+	CompressedBytes int64         //压缩后字节数
+	CompressTime    time.Duration //压缩耗时
+	Decompressed    int64         //解压的帧数
+	DecompressTime  time.Duration //解压耗时
+}
+
+// Ratio 压缩率（压缩后/压缩前），越小越好
+func (s CompressCodeStats) Ratio() float64 {
+	if s.RawBytes == 0 {
+		return 0
+	}
+	return float64(s.CompressedBytes) / float64(s.RawBytes)
+}
+
+// CompressStats 按消息编号（消息开头4字节大端）统计压缩率和耗时，编码器和所有连接的解码器共享
+type CompressStats struct {
+	mutex sync.Mutex
+	codes map[int]*CompressCodeStats
+}
+
+func NewCompressStats() *CompressStats {
+	return &CompressStats{codes: make(map[int]*CompressCodeStats)}
+}
+
+func (s *CompressStats) update(code int, fn func(stats *CompressCodeStats)) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stats, ok := s.codes[code]
+	if !ok {
+		stats = new(CompressCodeStats)
+		s.codes[code] = stats
+	}
+	fn(stats)
+}
+
+// Snapshot 当前统计
+func (s *CompressStats) Snapshot() map[int]CompressCodeStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	snapshot := make(map[int]CompressCodeStats, len(s.codes))
+	for code, stats := range s.codes {
+		snapshot[code] = *stats
+	}
+	return snapshot
+}
+
+func (c *Compression) withDefaults() Compression {
+	config := *c
+	if config.Algorithm == nil {
+		config.Algorithm = Deflate
+	}
+	if config.MaxFrameLength == 0 {
+		config.MaxFrameLength = 327670
+	}
+	if config.Threshold == 0 {
+		config.Threshold = min(defaultCompressThreshold, max(config.MaxFrameLength, 0))
+	}
+	return config
+}
+
+// compressFlag 长度字段的最高位
+func compressFlag(lengthFieldLength int) uint64 {
+	return 1 << (lengthFieldLength*8 - 1)
+}
+
+// check 检查补全默认值后的配置，长度字段最高位是压缩标记，未压缩帧的长度不能达到该位
+func (c *Compression) check(lengthFieldLength int) error {
+	config := c.withDefaults()
+	if config.MaxFrameLength < 0 {
+		return errors.New("negative maxFrameLength: " + strconv.Itoa(config.MaxFrameLength))
+	}
+	if config.Threshold < 0 || config.Threshold > config.MaxFrameLength {
+		return errors.New("threshold (" + strconv.Itoa(config.Threshold) + ") out of range: 0-" + strconv.Itoa(config.MaxFrameLength))
+	}
+	if uint64(config.MaxFrameLength) >= compressFlag(lengthFieldLength) {
+		return errors.New("maxFrameLength (" + strconv.Itoa(config.MaxFrameLength) + ") reaches compress flag of lengthFieldLength: " +
+			strconv.Itoa(lengthFieldLength))
+	}
+	return nil
+}
+
+// CompressEncoder 超过阈值的消息压缩后编码，对应CompressDecoder，可在多个连接间共享
+type CompressEncoder struct {
+	LengthEncoder
+	config Compression
+}
+
+// NewCompressEncoder 构建压缩编码，指定字节序和长度字段字节数（1、2、4、8）
+func NewCompressEncoder(byteOrder binary.ByteOrder, lengthFieldLength int, config Compression) (Encoder, error) {
+	encoder := &CompressEncoder{config: config.withDefaults()}
+	if err := encoder.Init(byteOrder, lengthFieldLength); err != nil {
+		return nil, err
+	}
+	if err := config.check(lengthFieldLength); err != nil {
+		return nil, err
+	}
+	return encoder, nil
+}
+
+// Encode 对数据进行编码，压缩后没有变小时不压缩
+func (encoder *CompressEncoder) Encode(cmd buffer.PacketCons) []byte {
+	b := encoder.LengthEncoder.Encode(cmd)
+	n := encoder.lengthFieldLength
+	body := b[n:]
+	code := cmd.CodeId()
+	if len(body) < encoder.config.Threshold {
+		encoder.config.Stats.update(code, func(stats *CompressCodeStats) {
+			stats.Frames++
+		})
+		return b
+	}
+	start := time.Now()
+	out := make([]byte, n+1, n+1+len(body)/2)
+	out[n] = encoder.config.Algorithm.Id()
+	out, err := encoder.config.Algorithm.Compress(out, body)
+	elapsed := time.Since(start)
+	if err != nil || len(out) >= len(b) {
+		encoder.config.Stats.update(code, func(stats *CompressCodeStats) {
+			stats.Frames++
+			stats.CompressTime += elapsed
+		})
+		return b
+	}
+	encoder.putLength(out, uint64(len(out)-n)|compressFlag(n))
+	encoder.config.Stats.update(code, func(stats *CompressCodeStats) {
+		stats.Frames++
+		stats.Compressed++
+		stats.RawBytes += int64(len(body))
+		stats.CompressedBytes += int64(len(out) - n)
+		stats.CompressTime += elapsed
+	})
+	return out
+}
+
+// CompressDecoder 长度字段解码，最高位为压缩标记时解压，对应CompressEncoder，每个连接一个
+type CompressDecoder struct {
+	byteOrder         binary.ByteOrder
+	lengthFieldLength int
+	config            Compression
+}
+
+// NewCompressDecoder 构建压缩解码，指定字节序和长度字段字节数（1、2、4、8）
+func NewCompressDecoder(byteOrder binary.ByteOrder, lengthFieldLength int, config Compression) (Handler, error) {
+	if byteOrder == nil {
+		return nil, errors.New("byteOrder nil")
+	}
+	switch lengthFieldLength {
+	case 1, 2, 4, 8:
+	default:
+		return nil, errors.New("unsupported lengthFieldLength: " + strconv.Itoa(lengthFieldLength) + " (expected: 1, 2, 4, or 8)")
+	}
+	if err := config.check(lengthFieldLength); err != nil {
+		return nil, err
+	}
+	return &CompressDecoder{byteOrder: byteOrder, lengthFieldLength: lengthFieldLength, config: config.withDefaults()}, nil
+}
+
+// IsValidLength 判断是否是有效长度
+func (decoder *CompressDecoder) IsValidLength(length int) bool {
+	if length <= 0 || length > decoder.config.MaxFrameLength {
+		return false
+	}
+	return true
+}
+
+// Decode 对数据进行解码，压缩帧解压后返回
+func (decoder *CompressDecoder) Decode(in *buffer.ByteBuf) (*buffer.ByteBuf, error) {
+	n := decoder.lengthFieldLength
+	if in.ReadableBytes() < n {
+		return nil, nil
+	}
+	readerIndex := in.ReaderIndex()
+	field := decoder.getLength(in.Bytes()[:n])
+	flag := compressFlag(n)
+	compressed := field&flag != 0
+	length := int(field &^ flag)
+	if length > decoder.config.MaxFrameLength {
+		in.SkipBytes(in.ReadableBytes())
+		return nil, errors.New("Adjusted frame length (" + strconv.Itoa(length) + ") is more " +
+			"than maxFrameLength: " + strconv.Itoa(decoder.config.MaxFrameLength))
+	}
+	if in.ReadableBytes() < n+length {
+		return nil, nil
+	}
+	in.ReaderToIndex(readerIndex + n + length)
+	if !compressed {
+		return in.RetainedSlice(readerIndex+n, length), nil
+	}
+	frame := in.RetainedSlice(readerIndex+n, length).Bytes()
+	if len(frame) == 0 {
+		return nil, errCompressAlgorithm
+	}
+	compressor, ok := compressors[frame[0]]
+	if !ok {
+		return nil, errCompressAlgorithm
+	}
+	start := time.Now()
+	body, err := compressor.Decompress(frame[1:], decoder.config.MaxFrameLength)
+	if err != nil {
+		return nil, err
+	}
+	elapsed := time.Since(start)
+	if len(body) >= 4 {
+		decoder.config.Stats.update(int(int32(binary.BigEndian.Uint32(body))), func(stats *CompressCodeStats) {
+			stats.Decompressed++
+			stats.DecompressTime += elapsed
+		})
+	}
+	return buffer.NewBuffer(body), nil
+}
+
+func (decoder *CompressDecoder) getLength(b []byte) uint64 {
+	switch len(b) {
+	case 1:
+		return uint64(b[0])
+	case 2:
+		return uint64(decoder.byteOrder.Uint16(b))
+	case 4:
+		return uint64(decoder.byteOrder.Uint32(b))
+	default:
+		return decoder.byteOrder.Uint64(b)
+	}
+}
@@ -0,0 +1,89 @@
+//对发送的消息进行编码，与解码器对应
+
+package handler
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/yhhaiua/engine/buffer"
+	"strconv"
+)
+
+// Encoder 消息编码，返回可直接发送的完整帧
+type Encoder interface {
+	Encode(cmd buffer.PacketCons) []byte
+}
+
+// LengthEncoder 在消息前写入长度字段，对应LengthDecoder
+type LengthEncoder struct {
+	byteOrder         binary.ByteOrder
+	lengthFieldLength int
+}
+
+// NewLengthEncoder 构建编码，4字节大端长度
+func NewLengthEncoder() Encoder {
+	encoder := new(LengthEncoder)
+	_ = encoder.Init(binary.BigEndian, 4)
+	return encoder
+}
+
+// NewLengthEncoderField 构建编码，指定字节序和长度字段字节数（1、2、4、8）
+func NewLengthEncoderField(byteOrder binary.ByteOrder, lengthFieldLength int) (Encoder, error) {
+	encoder := new(LengthEncoder)
+	err := encoder.Init(byteOrder, lengthFieldLength)
+	return encoder, err
+}
+
+// Init 初始化构建编码器
+func (encoder *LengthEncoder) Init(byteOrder binary.ByteOrder, lengthFieldLength int) error {
+	if byteOrder == nil {
+		return errors.New("byteOrder nil")
+	}
+	switch lengthFieldLength {
+	case 1, 2, 4, 8:
+	default:
+		return errors.New("unsupported lengthFieldLength: " + strconv.Itoa(lengthFieldLength) + " (expected: 1, 2, 4, or 8)")
+	}
+	encoder.byteOrder = byteOrder
+	encoder.lengthFieldLength = lengthFieldLength
+	return nil
+}
+
+// Encode 对数据进行编码
+func (encoder *LengthEncoder) Encode(cmd buffer.PacketCons) []byte {
+	msg := buffer.NewByteBuf()
+	_, _ = msg.Write(make([]byte, encoder.lengthFieldLength))
+	cmd.Write(msg)
+	b := msg.Bytes()
+	encoder.putLength(b, uint64(len(b)-encoder.lengthFieldLength))
+	return b
+}
+
+// putLength 写入长度字段
+func (encoder *LengthEncoder) putLength(b []byte, length uint64) {
+	switch encoder.lengthFieldLength {
+	case 1:
+		b[0] = byte(length)
+	case 2:
+		encoder.byteOrder.PutUint16(b, uint16(length))
+	case 4:
+		encoder.byteOrder.PutUint32(b, uint32(length))
+	case 8:
+		encoder.byteOrder.PutUint64(b, length)
+	}
+}
+
+// WsEncoder websocket自带消息边界，直接发送消息内容，对应WsDecoder
+type WsEncoder struct {
+}
+
+func NewWsEncoder() Encoder {
+	return new(WsEncoder)
+}
+
+// Encode 对数据进行编码
+func (encoder *WsEncoder) Encode(cmd buffer.PacketCons) []byte {
+	msg := buffer.NewByteBuf()
+	cmd.Write(msg)
+	return msg.Bytes()
+}
@@ -8,3 +8,6 @@ type Handler interface {
 	IsValidLength(length int) bool //是否有效长度
 	Decode(in *buffer.ByteBuf) (*buffer.ByteBuf, error)
 }
+
+// NewHandler 为每个连接创建解码器，解码器有状态不可共享
+type NewHandler func() (Handler, error)
@@ -39,6 +39,14 @@ func NewLengthDecoderClient(length int) (Handler, error) {
 	return handler, err
 }
 
+//NewLengthDecoderField 构建解码，指定字节序和长度字段字节数（1、2、4、8）
+func NewLengthDecoderField(byteOrder binary.ByteOrder, maxFrameLength, lengthFieldLength int) (Handler, error) {
+	handler := new(LengthDecoder)
+	err := handler.Init(byteOrder, maxFrameLength, 0,
+		lengthFieldLength, 0, lengthFieldLength)
+	return handler, err
+}
+
 // Init 初始化构建解码器
 func (decoder *LengthDecoder) Init(byteOrder binary.ByteOrder, maxFrameLength, lengthFieldOffset,
 	lengthFieldLength, lengthAdjustment, initialBytesToStrip int) error {
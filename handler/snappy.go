@@ -0,0 +1,177 @@
+//Snappy块格式（不含分块流格式）的压缩和解压，按google snappy的format_description.txt实现
+
+package handler
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	snappyTagLiteral = 0x00
+	snappyTagCopy1   = 0x01
+	snappyTagCopy2   = 0x02
+	snappyTagCopy4   = 0x03
+
+	snappyMinMatch     = 4
+	snappyMinInput     = 17 //小于该长度直接作为字面量
+	snappyHashLog      = 14
+	snappyMaxCopy1Off  = 1 << 11
+	snappyMaxCopy2Off  = 1 << 16
+	snappyMaxCopyChunk = 64
+)
+
+var errSnappyCorrupt = errors.New("snappy: corrupt input")
+
+func snappyHash(u uint32) uint32 {
+	return (u * 0x1e35a7bd) >> (32 - snappyHashLog)
+}
+
+// snappyEncode 压缩src追加到dst
+func snappyEncode(dst, src []byte) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(src)))
+	if len(src) < snappyMinInput {
+		return snappyLiteral(dst, src)
+	}
+	var table [1 << snappyHashLog]int32
+	for i := range table {
+		table[i] = -1
+	}
+	nextEmit := 0
+	s := 0
+	limit := len(src) - snappyMinMatch
+	for s <= limit {
+		cur := binary.LittleEndian.Uint32(src[s:])
+		h := snappyHash(cur)
+		candidate := int(table[h])
+		table[h] = int32(s)
+		if candidate < 0 || binary.LittleEndian.Uint32(src[candidate:]) != cur {
+			//连续未匹配时加大步长
+			s += 1 + (s-nextEmit)>>5
+			continue
+		}
+		dst = snappyLiteral(dst, src[nextEmit:s])
+		length := snappyMinMatch
+		for s+length < len(src) && src[candidate+length] == src[s+length] {
+			length++
+		}
+		dst = snappyCopy(dst, s-candidate, length)
+		s += length
+		nextEmit = s
+	}
+	return snappyLiteral(dst, src[nextEmit:])
+}
+
+func snappyLiteral(dst, lit []byte) []byte {
+	n := len(lit)
+	if n == 0 {
+		return dst
+	}
+	n--
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n)<<2|snappyTagLiteral)
+	case n < 1<<8:
+		dst = append(dst, 60<<2|snappyTagLiteral, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2|snappyTagLiteral, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2|snappyTagLiteral, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2|snappyTagLiteral, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(dst, lit...)
+}
+
+func snappyCopy(dst []byte, offset, length int) []byte {
+	for length > 0 {
+		chunk := length
+		if chunk > snappyMaxCopyChunk {
+			chunk = snappyMaxCopyChunk
+			//保证剩余部分不少于copy1的最小长度
+			if length-chunk < snappyMinMatch {
+				chunk = length - snappyMinMatch
+			}
+		}
+		length -= chunk
+		switch {
+		case chunk >= snappyMinMatch && chunk < 12 && offset < snappyMaxCopy1Off:
+			dst = append(dst, byte(offset>>8)<<5|byte(chunk-4)<<2|snappyTagCopy1, byte(offset))
+		case offset < snappyMaxCopy2Off:
+			dst = append(dst, byte(chunk-1)<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+		default:
+			dst = append(dst, byte(chunk-1)<<2|snappyTagCopy4, byte(offset), byte(offset>>8), byte(offset>>16), byte(offset>>24))
+		}
+	}
+	return dst
+}
+
+// snappyDecode 解压，解压后长度超过maxSize时返回错误
+func snappyDecode(src []byte, maxSize int) ([]byte, error) {
+	n, header := binary.Uvarint(src)
+	if header <= 0 || n > uint64(maxSize) {
+		return nil, errSnappyCorrupt
+	}
+	dst := make([]byte, n)
+	d := 0
+	s := header
+	for s < len(src) {
+		var length, offset int
+		switch src[s] & 0x03 {
+		case snappyTagLiteral:
+			x := int(src[s] >> 2)
+			s++
+			if x >= 60 {
+				size := x - 59
+				if s+size > len(src) {
+					return nil, errSnappyCorrupt
+				}
+				x = 0
+				for i := size - 1; i >= 0; i-- {
+					x = x<<8 | int(src[s+i])
+				}
+				s += size
+			}
+			length = x + 1
+			if length > len(src)-s || length > len(dst)-d {
+				return nil, errSnappyCorrupt
+			}
+			copy(dst[d:], src[s:s+length])
+			d += length
+			s += length
+			continue
+		case snappyTagCopy1:
+			if s+2 > len(src) {
+				return nil, errSnappyCorrupt
+			}
+			length = 4 + int(src[s]>>2)&0x07
+			offset = int(src[s]&0xe0)<<3 | int(src[s+1])
+			s += 2
+		case snappyTagCopy2:
+			if s+3 > len(src) {
+				return nil, errSnappyCorrupt
+			}
+			length = 1 + int(src[s]>>2)
+			offset = int(binary.LittleEndian.Uint16(src[s+1:]))
+			s += 3
+		case snappyTagCopy4:
+			if s+5 > len(src) {
+				return nil, errSnappyCorrupt
+			}
+			length = 1 + int(src[s]>>2)
+			offset = int(binary.LittleEndian.Uint32(src[s+1:]))
+			s += 5
+		}
+		if offset <= 0 || offset > d || length > len(dst)-d {
+			return nil, errSnappyCorrupt
+		}
+		//可能重叠，逐字节复制
+		for end := d + length; d < end; d++ {
+			dst[d] = dst[d-offset]
+		}
+	}
+	if d != len(dst) {
+		return nil, errSnappyCorrupt
+	}
+	return dst, nil
+}
@@ -0,0 +1,520 @@
+package net
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// 可靠udp的ARQ实现，协议格式与KCP一致（24字节头，小端），另增加关闭命令。
+// arq不加锁，只在连接自己的协程中使用
+
+const (
+	arqCmdPush = 81 //数据
+	arqCmdAck  = 82 //确认
+	arqCmdWask = 83 //询问对方窗口
+	arqCmdWins = 84 //告知自己窗口（也用作保活）
+	arqCmdFin  = 85 //关闭连接
+
+	arqAskSend = 1 //需要发送wask
+	arqAskTell = 2 //需要发送wins
+
+	arqOverhead    = 24
+	arqRtoMin      = 100
+	arqRtoNoDelay  = 30
+	arqRtoDefault  = 200
+	arqRtoMax      = 60000
+	arqThreshInit  = 2
+	arqThreshMin   = 2
+	arqProbeInit   = 7000
+	arqProbeLimit  = 120000
+	arqFragmentMax = 255 //单条消息最大分片数
+)
+
+var (
+	errArqConv    = errors.New("arq conv mismatch")
+	errArqPacket  = errors.New("arq invalid packet")
+	errArqMsgSize = errors.New("arq message too large")
+)
+
+var arqEpoch = time.Now()
+
+// arqNow 毫秒时间戳，只用于计算差值
+func arqNow() uint32 {
+	return uint32(time.Since(arqEpoch) / time.Millisecond)
+}
+
+// timeDiff 回绕安全的时间/序号比较
+func timeDiff(later, earlier uint32) int32 {
+	return int32(later - earlier)
+}
+
+type arqSegment struct {
+	conv     uint32
+	cmd      uint8
+	frg      uint8
+	wnd      uint16
+	ts       uint32
+	sn       uint32
+	una      uint32
+	resendTs uint32
+	rto      uint32
+	fastAck  uint32
+	xmit     uint32
+	data     []byte
+}
+
+func (seg *arqSegment) encode(buf []byte) []byte {
+	var header [arqOverhead]byte
+	binary.LittleEndian.PutUint32(header[0:], seg.conv)
+	header[4] = seg.cmd
+	header[5] = seg.frg
+	binary.LittleEndian.PutUint16(header[6:], seg.wnd)
+	binary.LittleEndian.PutUint32(header[8:], seg.ts)
+	binary.LittleEndian.PutUint32(header[12:], seg.sn)
+	binary.LittleEndian.PutUint32(header[16:], seg.una)
+	binary.LittleEndian.PutUint32(header[20:], uint32(len(seg.data)))
+	buf = append(buf, header[:]...)
+	return append(buf, seg.data...)
+}
+
+type arqAck struct {
+	sn uint32
+	ts uint32
+}
+
+// ArqStats 可靠udp连接的重传统计
+type ArqStats struct {
+	Sent        int64  //发送的数据分片数（不含重传）
+	Retransmits int64  //超时重传次数
+	FastResends int64  //快速重传次数
+	Rtt         uint32 //平滑后的往返时间（毫秒）
+}
+
+type arq struct {
+	conv, mtu, mss             uint32
+	sndUna, sndNxt, rcvNxt     uint32
+	ssthresh                   uint32
+	rxRttVar, rxSrtt           int32
+	rxRto, rxMinRto            uint32
+	sndWnd, rcvWnd, rmtWnd     uint32
+	cwnd, incr, probe          uint32
+	interval                   uint32
+	tsProbe, probeWait         uint32
+	deadLink                   uint32
+	fastResend                 uint32
+	noDelay, noCwnd, dead      bool
+	sndQueue, rcvQueue         []*arqSegment
+	sndBuf, rcvBuf             []*arqSegment
+	ackList                    []arqAck
+	buffer                     []byte
+	output                     func(buf []byte)
+	sent, retransmits, resends int64
+}
+
+func newArq(conv uint32, config *UDPConfig, output func(buf []byte)) *arq {
+	a := &arq{
+		conv:       conv,
+		mtu:        uint32(config.MTU),
+		sndWnd:     uint32(config.SendWindow),
+		rcvWnd:     uint32(config.RecvWindow),
+		rmtWnd:     uint32(config.RecvWindow),
+		rxRto:      arqRtoDefault,
+		rxMinRto:   arqRtoMin,
+		ssthresh:   arqThreshInit,
+		interval:   uint32(config.Interval / time.Millisecond),
+		deadLink:   uint32(config.DeadLink),
+		fastResend: uint32(config.FastResend),
+		noDelay:    config.NoDelay,
+		noCwnd:     config.NoCongestion,
+		output:     output,
+	}
+	a.mss = a.mtu - arqOverhead
+	a.buffer = make([]byte, 0, a.mtu)
+	if a.noDelay {
+		a.rxMinRto = arqRtoNoDelay
+	}
+	return a
+}
+
+// send 消息分片后放入发送队列
+func (a *arq) send(msg []byte) error {
+	count := (len(msg) + int(a.mss) - 1) / int(a.mss)
+	if count == 0 {
+		count = 1
+	}
+	if count > arqFragmentMax || uint32(count) > a.rcvWnd {
+		return errArqMsgSize
+	}
+	for i := 0; i < count; i++ {
+		size := len(msg)
+		if size > int(a.mss) {
+			size = int(a.mss)
+		}
+		seg := &arqSegment{frg: uint8(count - i - 1)}
+		seg.data = append([]byte(nil), msg[:size]...)
+		a.sndQueue = append(a.sndQueue, seg)
+		msg = msg[size:]
+	}
+	return nil
+}
+
+// recv 取出一条完整消息，没有时返回nil
+func (a *arq) recv() []byte {
+	if len(a.rcvQueue) == 0 {
+		return nil
+	}
+	count := int(a.rcvQueue[0].frg) + 1
+	if len(a.rcvQueue) < count {
+		return nil
+	}
+	full := uint32(len(a.rcvQueue)) >= a.rcvWnd
+	var msg []byte
+	for _, seg := range a.rcvQueue[:count] {
+		msg = append(msg, seg.data...)
+	}
+	clear(a.rcvQueue[:count])
+	a.rcvQueue = a.rcvQueue[count:]
+	a.moveRcvBuf()
+	//接收窗口从满恢复，主动告知对方
+	if full && uint32(len(a.rcvQueue)) < a.rcvWnd {
+		a.probe |= arqAskTell
+	}
+	return msg
+}
+
+// waitSend 等待发送和等待确认的分片数
+func (a *arq) waitSend() int {
+	return len(a.sndBuf) + len(a.sndQueue)
+}
+
+// input 处理收到的udp包
+func (a *arq) input(data []byte) error {
+	prevUna := a.sndUna
+	var maxAck, latestTs uint32
+	hasAck := false
+	current := arqNow()
+	for len(data) >= arqOverhead {
+		conv := binary.LittleEndian.Uint32(data)
+		if conv != a.conv {
+			return errArqConv
+		}
+		cmd := data[4]
+		frg := data[5]
+		wnd := binary.LittleEndian.Uint16(data[6:])
+		ts := binary.LittleEndian.Uint32(data[8:])
+		sn := binary.LittleEndian.Uint32(data[12:])
+		una := binary.LittleEndian.Uint32(data[16:])
+		length := binary.LittleEndian.Uint32(data[20:])
+		data = data[arqOverhead:]
+		if uint32(len(data)) < length {
+			return errArqPacket
+		}
+		a.rmtWnd = uint32(wnd)
+		a.parseUna(una)
+		a.shrinkBuf()
+		switch cmd {
+		case arqCmdAck:
+			if timeDiff(current, ts) >= 0 {
+				a.updateAck(timeDiff(current, ts))
+			}
+			a.parseAck(sn)
+			a.shrinkBuf()
+			if !hasAck || timeDiff(sn, maxAck) > 0 {
+				hasAck = true
+				maxAck, latestTs = sn, ts
+			}
+		case arqCmdPush:
+			if timeDiff(sn, a.rcvNxt+a.rcvWnd) < 0 {
+				a.ackList = append(a.ackList, arqAck{sn: sn, ts: ts})
+				if timeDiff(sn, a.rcvNxt) >= 0 {
+					seg := &arqSegment{cmd: cmd, frg: frg, sn: sn}
+					seg.data = append([]byte(nil), data[:length]...)
+					a.parseData(seg)
+				}
+			}
+		case arqCmdWask:
+			a.probe |= arqAskTell
+		case arqCmdWins:
+		default:
+			return errArqPacket
+		}
+		data = data[length:]
+	}
+	if hasAck {
+		a.parseFastAck(maxAck, latestTs)
+	}
+	//有新确认时增大拥塞窗口
+	if timeDiff(a.sndUna, prevUna) > 0 && a.cwnd < a.rmtWnd {
+		if a.cwnd < a.ssthresh {
+			a.cwnd++
+			a.incr += a.mss
+		} else {
+			if a.incr < a.mss {
+				a.incr = a.mss
+			}
+			a.incr += (a.mss*a.mss)/a.incr + a.mss/16
+			if (a.cwnd+1)*a.mss <= a.incr {
+				a.cwnd = (a.incr + a.mss - 1) / a.mss
+			}
+		}
+		if a.cwnd > a.rmtWnd {
+			a.cwnd = a.rmtWnd
+			a.incr = a.rmtWnd * a.mss
+		}
+	}
+	return nil
+}
+
+func (a *arq) updateAck(rtt int32) {
+	if a.rxSrtt == 0 {
+		a.rxSrtt = rtt
+		a.rxRttVar = rtt / 2
+	} else {
+		delta := rtt - a.rxSrtt
+		if delta < 0 {
+			delta = -delta
+		}
+		a.rxRttVar = (3*a.rxRttVar + delta) / 4
+		a.rxSrtt = (7*a.rxSrtt + rtt) / 8
+		if a.rxSrtt < 1 {
+			a.rxSrtt = 1
+		}
+	}
+	rto := uint32(a.rxSrtt) + max(a.interval, uint32(4*a.rxRttVar))
+	a.rxRto = min(max(rto, a.rxMinRto), arqRtoMax)
+}
+
+func (a *arq) shrinkBuf() {
+	if len(a.sndBuf) > 0 {
+		a.sndUna = a.sndBuf[0].sn
+	} else {
+		a.sndUna = a.sndNxt
+	}
+}
+
+// parseAck 选择确认，移除对应分片
+func (a *arq) parseAck(sn uint32) {
+	if timeDiff(sn, a.sndUna) < 0 || timeDiff(sn, a.sndNxt) >= 0 {
+		return
+	}
+	for i, seg := range a.sndBuf {
+		if seg.sn == sn {
+			a.sndBuf = append(a.sndBuf[:i], a.sndBuf[i+1:]...)
+			return
+		}
+		if timeDiff(sn, seg.sn) < 0 {
+			return
+		}
+	}
+}
+
+// parseUna 累计确认，移除una之前的分片
+func (a *arq) parseUna(una uint32) {
+	n := 0
+	for _, seg := range a.sndBuf {
+		if timeDiff(una, seg.sn) <= 0 {
+			break
+		}
+		n++
+	}
+	if n > 0 {
+		clear(a.sndBuf[:n])
+		a.sndBuf = a.sndBuf[n:]
+	}
+}
+
+// parseFastAck 比sn早发送但未确认的分片跳过一次
+func (a *arq) parseFastAck(sn, ts uint32) {
+	if timeDiff(sn, a.sndUna) < 0 || timeDiff(sn, a.sndNxt) >= 0 {
+		return
+	}
+	for _, seg := range a.sndBuf {
+		if timeDiff(sn, seg.sn) < 0 {
+			break
+		}
+		if sn != seg.sn && timeDiff(ts, seg.ts) >= 0 {
+			seg.fastAck++
+		}
+	}
+}
+
+// parseData 按序号插入接收缓存，去重
+func (a *arq) parseData(seg *arqSegment) {
+	sn := seg.sn
+	if timeDiff(sn, a.rcvNxt+a.rcvWnd) >= 0 || timeDiff(sn, a.rcvNxt) < 0 {
+		return
+	}
+	i := len(a.rcvBuf)
+	for ; i > 0; i-- {
+		prev := a.rcvBuf[i-1]
+		if prev.sn == sn {
+			return
+		}
+		if timeDiff(sn, prev.sn) > 0 {
+			break
+		}
+	}
+	a.rcvBuf = append(a.rcvBuf, nil)
+	copy(a.rcvBuf[i+1:], a.rcvBuf[i:])
+	a.rcvBuf[i] = seg
+	a.moveRcvBuf()
+}
+
+// moveRcvBuf 连续的分片移入接收队列
+func (a *arq) moveRcvBuf() {
+	n := 0
+	for _, seg := range a.rcvBuf {
+		if seg.sn != a.rcvNxt || uint32(len(a.rcvQueue)) >= a.rcvWnd {
+			break
+		}
+		a.rcvQueue = append(a.rcvQueue, seg)
+		a.rcvNxt++
+		n++
+	}
+	if n > 0 {
+		clear(a.rcvBuf[:n])
+		a.rcvBuf = a.rcvBuf[n:]
+	}
+}
+
+func (a *arq) wndUnused() uint16 {
+	if n := uint32(len(a.rcvQueue)); n < a.rcvWnd {
+		return uint16(a.rcvWnd - n)
+	}
+	return 0
+}
+
+// write 写入分片，超过mtu时先输出
+func (a *arq) write(seg *arqSegment) {
+	if len(a.buffer)+arqOverhead+len(seg.data) > int(a.mtu) {
+		a.flushBuffer()
+	}
+	a.buffer = seg.encode(a.buffer)
+}
+
+func (a *arq) flushBuffer() {
+	if len(a.buffer) > 0 {
+		a.output(a.buffer)
+		a.buffer = a.buffer[:0]
+	}
+}
+
+// flush 发送确认、窗口探测和数据分片，处理超时重传和快速重传，由连接协程每个interval调用
+func (a *arq) flush() {
+	current := arqNow()
+	seg := arqSegment{conv: a.conv, cmd: arqCmdAck, wnd: a.wndUnused(), una: a.rcvNxt}
+	for _, ack := range a.ackList {
+		seg.sn, seg.ts = ack.sn, ack.ts
+		a.write(&seg)
+	}
+	a.ackList = a.ackList[:0]
+
+	//对方窗口为0时定期询问
+	if a.rmtWnd == 0 {
+		if a.probeWait == 0 {
+			a.probeWait = arqProbeInit
+			a.tsProbe = current + a.probeWait
+		} else if timeDiff(current, a.tsProbe) >= 0 {
+			a.probeWait = min(a.probeWait+a.probeWait/2, arqProbeLimit)
+			a.tsProbe = current + a.probeWait
+			a.probe |= arqAskSend
+		}
+	} else {
+		a.tsProbe, a.probeWait = 0, 0
+	}
+	seg.sn, seg.ts = 0, 0
+	if a.probe&arqAskSend != 0 {
+		seg.cmd = arqCmdWask
+		a.write(&seg)
+	}
+	if a.probe&arqAskTell != 0 {
+		seg.cmd = arqCmdWins
+		a.write(&seg)
+	}
+	a.probe = 0
+
+	cwnd := min(a.sndWnd, a.rmtWnd)
+	if !a.noCwnd {
+		cwnd = min(cwnd, max(a.cwnd, 1))
+	}
+	for timeDiff(a.sndNxt, a.sndUna+cwnd) < 0 && len(a.sndQueue) > 0 {
+		newSeg := a.sndQueue[0]
+		a.sndQueue[0] = nil
+		a.sndQueue = a.sndQueue[1:]
+		newSeg.conv = a.conv
+		newSeg.cmd = arqCmdPush
+		newSeg.sn = a.sndNxt
+		a.sndNxt++
+		a.sndBuf = append(a.sndBuf, newSeg)
+	}
+
+	resent := a.fastResend
+	if resent == 0 {
+		resent = ^uint32(0)
+	}
+	rtoMin := a.rxRto >> 3
+	if a.noDelay {
+		rtoMin = 0
+	}
+	lost, change := false, false
+	for _, s := range a.sndBuf {
+		send := false
+		switch {
+		case s.xmit == 0:
+			send = true
+			s.rto = a.rxRto
+			s.resendTs = current + s.rto + rtoMin
+			a.sent++
+		case timeDiff(current, s.resendTs) >= 0:
+			send = true
+			if a.noDelay {
+				s.rto += a.rxRto / 2
+			} else {
+				s.rto += max(s.rto, a.rxRto)
+			}
+			s.resendTs = current + s.rto
+			lost = true
+			a.retransmits++
+		case s.fastAck >= resent:
+			send = true
+			s.fastAck = 0
+			s.resendTs = current + s.rto
+			change = true
+			a.resends++
+		}
+		if !send {
+			continue
+		}
+		s.xmit++
+		s.ts = current
+		s.wnd = seg.wnd
+		s.una = a.rcvNxt
+		a.write(s)
+		if a.deadLink > 0 && s.xmit >= a.deadLink {
+			a.dead = true
+		}
+	}
+	a.flushBuffer()
+
+	if change {
+		inflight := a.sndNxt - a.sndUna
+		a.ssthresh = max(inflight/2, arqThreshMin)
+		a.cwnd = a.ssthresh + resent
+		a.incr = a.cwnd * a.mss
+	}
+	if lost {
+		a.ssthresh = max(cwnd/2, arqThreshMin)
+		a.cwnd = 1
+		a.incr = a.mss
+	}
+	if a.cwnd < 1 {
+		a.cwnd = 1
+		a.incr = a.mss
+	}
+}
+
+// stats 重传统计
+func (a *arq) stats() ArqStats {
+	return ArqStats{Sent: a.sent, Retransmits: a.retransmits, FastResends: a.resends, Rtt: uint32(a.rxSrtt)}
+}
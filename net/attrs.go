@@ -0,0 +1,99 @@
+package net
+
+import "sync"
+
+// Attrs 连接上的属性（账号、角色id、登录阶段、语言等），并发安全
+type Attrs struct {
+	mutex  sync.RWMutex
+	values map[any]any
+}
+
+func (a *Attrs) get(key any) (any, bool) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	v, ok := a.values[key]
+	return v, ok
+}
+
+func (a *Attrs) set(key, value any) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.values == nil {
+		a.values = make(map[any]any)
+	}
+	a.values[key] = value
+}
+
+func (a *Attrs) delete(key any) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	delete(a.values, key)
+}
+
+// AttrChannel 支持属性的连接
+type AttrChannel interface {
+	Channel
+	Attrs() *Attrs
+}
+
+// channelAttrs 获取连接的属性，未实现AttrChannel时返回nil
+func channelAttrs(channel Channel) *Attrs {
+	if c, ok := channel.(AttrChannel); ok {
+		return c.Attrs()
+	}
+	return nil
+}
+
+// AttrKey 类型化的属性键，以指针区分，同名的不同键互不影响
+//
+//	var RoleId = net.NewAttrKey[int64]("roleId")
+//	RoleId.Set(conn, 1001)
+//	id, ok := RoleId.Get(conn)
+type AttrKey[T any] struct {
+	name string
+}
+
+func NewAttrKey[T any](name string) *AttrKey[T] {
+	return &AttrKey[T]{name: name}
+}
+
+func (k *AttrKey[T]) Name() string {
+	return k.name
+}
+
+// Get 读取属性，未设置或连接不支持属性时返回零值和false
+func (k *AttrKey[T]) Get(conn Channel) (T, bool) {
+	var zero T
+	attrs := channelAttrs(conn)
+	if attrs == nil {
+		return zero, false
+	}
+	v, ok := attrs.get(k)
+	if !ok {
+		return zero, false
+	}
+	return v.(T), true
+}
+
+// Value 读取属性，未设置时返回零值
+func (k *AttrKey[T]) Value(conn Channel) T {
+	v, _ := k.Get(conn)
+	return v
+}
+
+// Set 设置属性，连接不支持属性时忽略
+func (k *AttrKey[T]) Set(conn Channel, value T) {
+	attrs := channelAttrs(conn)
+	if attrs == nil {
+		logger.Warnf("channel attrs not supported,attr:%s", k.name)
+		return
+	}
+	attrs.set(k, value)
+}
+
+// Delete 删除属性
+func (k *AttrKey[T]) Delete(conn Channel) {
+	if attrs := channelAttrs(conn); attrs != nil {
+		attrs.delete(k)
+	}
+}
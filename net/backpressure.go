@@ -0,0 +1,168 @@
+package net
+
+import (
+	"github.com/yhhaiua/engine/util"
+	"time"
+)
+
+// BackpressurePolicy 写入队列已满（慢消费者）时的处理方式
+type BackpressurePolicy int
+
+const (
+	BackpressureBlock      BackpressurePolicy = iota //阻塞等待，Timeout大于0时超时后断开连接
+	BackpressureDropNewest                           //丢弃新消息
+	BackpressureDropOldest                           //丢弃队列中最早的消息
+	BackpressureDisconnect                           //断开连接
+)
+
+// Backpressure 写入队列已满时的处理配置
+type Backpressure struct {
+	Policy  BackpressurePolicy
+	Timeout time.Duration                                 //BackpressureBlock等待超时，0一直等待
+	OnFull  func(conn Channel, action BackpressurePolicy) //队列已满时回调实际执行的处理
+}
+
+// BackpressureStats 写入队列已满的统计
+type BackpressureStats struct {
+	Full        int64 //队列已满次数
+	Dropped     int64 //丢弃的消息数
+	Disconnects int64 //因队列已满断开的连接数（包含等待超时）
+}
+
+type backpressureCounter struct {
+	full        util.AtomicLong
+	dropped     util.AtomicLong
+	disconnects util.AtomicLong
+}
+
+func (c *backpressureCounter) snapshot() BackpressureStats {
+	return BackpressureStats{
+		Full:        c.full.Get(),
+		Dropped:     c.dropped.Get(),
+		Disconnects: c.disconnects.Get(),
+	}
+}
+
+// writeQueue 连接的写入队列
+type writeQueue struct {
+	chData    chan []byte
+	chDone    chan struct{} //写协程退出后关闭，之后不再阻塞写入
+	opts      *Options
+	metrics   *connMetrics //队列最大长度通过连接统计计入汇总
+	counter   backpressureCounter
+	closeFlag util.AtomicInteger
+	highWater util.AtomicInteger //队列最大长度
+}
+
+func newWriteQueue(size int, opts *Options, metrics *connMetrics) *writeQueue {
+	if opts.QueueSize > 0 {
+		size = opts.QueueSize
+	}
+	return &writeQueue{
+		chData:  make(chan []byte, size),
+		chDone:  make(chan struct{}),
+		opts:    opts,
+		metrics: metrics,
+	}
+}
+
+// push 写入队列，msg为nil时为关闭标记，队列已满时按策略处理，返回是否需要断开连接
+func (q *writeQueue) push(conn Channel, msg []byte) bool {
+	select {
+	case q.chData <- msg:
+		q.mark()
+		return false
+	default:
+	}
+	q.counter.full.IncrementAndGet()
+	q.opts.backpressure.full.IncrementAndGet()
+	bp := &q.opts.Backpressure
+	switch bp.Policy {
+	case BackpressureDisconnect:
+		q.report(conn, BackpressureDisconnect)
+		return true
+	case BackpressureDropNewest:
+		if msg != nil {
+			q.report(conn, BackpressureDropNewest)
+			return false
+		}
+	case BackpressureDropOldest:
+		if msg != nil {
+			q.dropOldest(conn, msg)
+			return false
+		}
+	}
+	//关闭标记不能丢弃，与阻塞策略一样等待
+	logger.Infof("队列已满进行等待,ip:%s,len:%d", conn.Ip(), len(q.chData))
+	var timeout <-chan time.Time
+	if bp.Timeout > 0 {
+		timer := time.NewTimer(bp.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case q.chData <- msg:
+		q.mark()
+		return false
+	case <-q.chDone:
+		return false
+	case <-timeout:
+		q.report(conn, BackpressureDisconnect)
+		return true
+	}
+}
+
+// mark 更新队列最大长度
+func (q *writeQueue) mark() {
+	n := int32(len(q.chData))
+	for {
+		old := q.highWater.Get()
+		if int(n) <= old || q.highWater.CompareAndSet(int32(old), n) {
+			break
+		}
+	}
+	q.metrics.queued(n)
+}
+
+// dropOldest 丢弃队列中最早的消息后写入
+func (q *writeQueue) dropOldest(conn Channel, msg []byte) {
+	select {
+	case old := <-q.chData:
+		if old == nil {
+			//已在关闭，放回关闭标记，丢弃新消息
+			select {
+			case q.chData <- nil:
+			case <-q.chDone:
+			}
+			q.report(conn, BackpressureDropNewest)
+			return
+		}
+	default:
+	}
+	q.report(conn, BackpressureDropOldest)
+	select {
+	case q.chData <- msg:
+	default:
+		//其他协程同时写入已占满队列
+		q.report(conn, BackpressureDropNewest)
+	}
+}
+
+func (q *writeQueue) report(conn Channel, action BackpressurePolicy) {
+	if action == BackpressureDisconnect {
+		logger.Warnf("write queue full,close:%s,len:%d", conn.Ip(), len(q.chData))
+		q.counter.disconnects.IncrementAndGet()
+		q.opts.backpressure.disconnects.IncrementAndGet()
+	} else {
+		q.counter.dropped.IncrementAndGet()
+		q.opts.backpressure.dropped.IncrementAndGet()
+	}
+	if q.opts.Backpressure.OnFull != nil {
+		q.opts.Backpressure.OnFull(conn, action)
+	}
+}
+
+// closed 是否已不再接受写入
+func (q *writeQueue) closed() bool {
+	return q.closeFlag.Get() == 1
+}
@@ -0,0 +1,97 @@
+package net
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newStalledConn 对端不读取数据，写协程未启动，队列满后无法消费
+func newStalledConn(opts ...Option) *TCPConn {
+	server, _ := net.Pipe()
+	return newTcpConn(server, &testListener{}, 1024, newOptions(opts...))
+}
+
+func TestBackpressurePolicies(t *testing.T) {
+	var mutex sync.Mutex
+	var actions []BackpressurePolicy
+	onFull := func(conn Channel, action BackpressurePolicy) {
+		mutex.Lock()
+		actions = append(actions, action)
+		mutex.Unlock()
+	}
+	lastAction := func() BackpressurePolicy {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return actions[len(actions)-1]
+	}
+
+	//丢弃新消息
+	conn := newStalledConn(WithQueueSize(2), WithBackpressure(BackpressureDropNewest, 0, onFull))
+	for i := 0; i < 3; i++ {
+		conn.WriteAndFlush([]byte{byte(i)})
+	}
+	if stats := conn.BackpressureStats(); stats.Full != 1 || stats.Dropped != 1 || lastAction() != BackpressureDropNewest {
+		t.Fatalf("drop newest %+v", stats)
+	}
+	if first := <-conn.queue.chData; first[0] != 0 {
+		t.Fatalf("first %v", first)
+	}
+
+	//丢弃最早的消息
+	conn = newStalledConn(WithQueueSize(2), WithBackpressure(BackpressureDropOldest, 0, onFull))
+	for i := 0; i < 3; i++ {
+		conn.WriteAndFlush([]byte{byte(i)})
+	}
+	if first := <-conn.queue.chData; first[0] != 1 || lastAction() != BackpressureDropOldest {
+		t.Fatalf("drop oldest first %v", first)
+	}
+
+	//断开连接
+	conn = newStalledConn(WithQueueSize(1), WithBackpressure(BackpressureDisconnect, 0, onFull))
+	conn.WriteAndFlush([]byte{0})
+	conn.WriteAndFlush([]byte{1})
+	if conn.connectAtomic.Get() != 1 || lastAction() != BackpressureDisconnect {
+		t.Fatal("slow consumer not disconnected")
+	}
+	if stats := conn.BackpressureStats(); stats.Disconnects != 1 {
+		t.Fatalf("disconnect %+v", stats)
+	}
+
+	//等待超时后断开，不阻塞调用者
+	opts := newOptions(WithQueueSize(1), WithBackpressure(BackpressureBlock, 50*time.Millisecond, onFull))
+	server, _ := net.Pipe()
+	conn = newTcpConn(server, &testListener{}, 1024, opts)
+	conn.WriteAndFlush([]byte{0})
+	start := time.Now()
+	conn.WriteAndFlush([]byte{1})
+	if time.Since(start) < 50*time.Millisecond || conn.connectAtomic.Get() != 1 {
+		t.Fatal("block timeout failed")
+	}
+	if stats := opts.backpressure.snapshot(); stats.Full != 1 || stats.Disconnects != 1 {
+		t.Fatalf("aggregate %+v", stats)
+	}
+}
+
+func TestBackpressureDestroyUnblocks(t *testing.T) {
+	//写协程退出后阻塞中的写入和关闭立即返回
+	conn := newStalledConn(WithQueueSize(1))
+	conn.WriteAndFlush([]byte{0})
+	go conn.run()
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			conn.WriteAndFlush([]byte{1})
+		}
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	conn.Destroy()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("writer blocked after destroy")
+	}
+	conn.Close()
+}
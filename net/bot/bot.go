@@ -0,0 +1,248 @@
+// Package bot 无界面机器人压测：启动N个tcp/websocket客户端，按剧本发送消息并等待回复，
+// 统计每个消息编号的吞吐量和延迟分位数
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/yhhaiua/engine/buffer"
+	"github.com/yhhaiua/engine/net"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTimeout = 5 * time.Second
+	inboxSize      = 1024
+)
+
+var (
+	// ErrTimeout 等待回复超时
+	ErrTimeout = errors.New("bot: wait reply timeout")
+	// ErrDisconnected 连接已断开
+	ErrDisconnected = errors.New("bot: disconnected")
+)
+
+// Scenario 机器人剧本，每个机器人循环执行，返回错误时计入Report.Errors后继续下一轮
+type Scenario func(b *Bot) error
+
+// Step 剧本中的一步
+type Step struct {
+	Send    buffer.PacketCons //发送的消息，为空只等待
+	Expect  int               //等待的回复编号，0不等待
+	Timeout time.Duration     //等待回复超时，默认5s
+	Think   time.Duration     //完成后等待的时间（模拟玩家操作间隔）
+}
+
+// Steps 按顺序执行的剧本，发送并等待回复时按发送的消息编号统计延迟
+func Steps(steps ...Step) Scenario {
+	return func(b *Bot) error {
+		for _, step := range steps {
+			var err error
+			switch {
+			case step.Send != nil && step.Expect != 0:
+				_, err = b.Request(step.Send, step.Expect, step.Timeout)
+			case step.Send != nil:
+				b.Send(step.Send)
+			case step.Expect != 0:
+				_, err = b.Expect(step.Expect, step.Timeout)
+			}
+			if err != nil {
+				return err
+			}
+			if err = b.Sleep(step.Think); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Packet 原始消息：4字节大端编号+内容，命令行剧本使用
+type Packet struct {
+	Code int
+	Body []byte
+}
+
+func (p *Packet) Write(buf *buffer.ByteBuf) {
+	buf.WriteNInt32(int32(p.Code))
+	_, _ = buf.Write(p.Body)
+}
+
+func (p *Packet) Read(buf *buffer.ByteBuf) {
+	p.Code = int(buf.ReadNInt32())
+	p.Body = append(p.Body[:0], buf.Bytes()...)
+	buf.SkipBytes(buf.ReadableBytes())
+}
+
+func (p *Packet) Copy() buffer.PacketCons {
+	return &Packet{Code: p.Code}
+}
+
+func (p *Packet) CodeId() int {
+	return p.Code
+}
+
+func (p *Packet) Module() string {
+	return "bot"
+}
+
+func (p *Packet) GetStage() int {
+	return 0
+}
+
+// Bot 单个机器人，剧本在机器人自己的协程中执行
+type Bot struct {
+	index  int
+	ctx    context.Context
+	runner *runner
+	mutex  sync.Mutex
+	conn   net.Channel
+	inbox  chan *buffer.ByteBuf
+	lost   chan struct{}
+	values map[string]any
+}
+
+func newBot(index int, ctx context.Context, r *runner) *Bot {
+	return &Bot{
+		index:  index,
+		ctx:    ctx,
+		runner: r,
+		inbox:  make(chan *buffer.ByteBuf, inboxSize),
+		lost:   make(chan struct{}),
+		values: make(map[string]any),
+	}
+}
+
+// Index 机器人编号，从0开始，可用于生成账号
+func (b *Bot) Index() int {
+	return b.index
+}
+
+// Context 机器人停止（减压或压测结束）时取消
+func (b *Bot) Context() context.Context {
+	return b.ctx
+}
+
+// Conn 当前连接
+func (b *Bot) Conn() net.Channel {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.conn
+}
+
+// Get 读取剧本保存的数据（如登录后的角色id）
+func (b *Bot) Get(key string) any {
+	return b.values[key]
+}
+
+// Set 保存剧本数据，只在机器人协程中使用
+func (b *Bot) Set(key string, value any) {
+	b.values[key] = value
+}
+
+// Send 发送消息，不等待回复
+func (b *Bot) Send(cmd buffer.PacketCons) {
+	conn := b.Conn()
+	if conn == nil {
+		return
+	}
+	net.NewTcpSession(conn).Post(cmd)
+	b.runner.stats.sent(cmd.CodeId())
+}
+
+// Expect 等待指定编号的消息，期间收到的其他消息被丢弃
+func (b *Bot) Expect(code int, timeout time.Duration) (*buffer.ByteBuf, error) {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case msg := <-b.inbox:
+			if c, err := b.runner.codeReader(msg); err == nil && c == code {
+				return msg, nil
+			}
+		case <-b.lost:
+			return nil, ErrDisconnected
+		case <-timer.C:
+			return nil, ErrTimeout
+		case <-b.ctx.Done():
+			return nil, b.ctx.Err()
+		}
+	}
+}
+
+// Request 发送消息并等待回复，按发送的消息编号统计延迟和错误
+func (b *Bot) Request(cmd buffer.PacketCons, replyCode int, timeout time.Duration) (*buffer.ByteBuf, error) {
+	start := time.Now()
+	b.Send(cmd)
+	msg, err := b.Expect(replyCode, timeout)
+	if err != nil && b.ctx.Err() != nil {
+		//停止时中断的请求不计入
+		return nil, err
+	}
+	b.runner.stats.request(cmd.CodeId(), time.Since(start), err)
+	return msg, err
+}
+
+// Sleep 等待d，机器人停止时提前返回
+func (b *Bot) Sleep(d time.Duration) error {
+	if d <= 0 {
+		return b.ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-b.ctx.Done():
+		return b.ctx.Err()
+	}
+}
+
+func (b *Bot) OnConnected(conn net.Channel) {
+	b.mutex.Lock()
+	b.conn = conn
+	b.mutex.Unlock()
+}
+
+func (b *Bot) OnDisconnected(conn net.Channel) {
+	conn.Destroy()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.conn == conn {
+		b.conn = nil
+		select {
+		case <-b.lost:
+		default:
+			close(b.lost)
+		}
+	}
+}
+
+// OnData 复制消息放入收件箱，收件箱满时丢弃最早的消息
+func (b *Bot) OnData(conn net.Channel, msg *buffer.ByteBuf) {
+	b.runner.stats.received()
+	copied := buffer.NewBuffer(append([]byte(nil), msg.Bytes()...))
+	for {
+		select {
+		case b.inbox <- copied:
+			return
+		default:
+		}
+		select {
+		case <-b.inbox:
+		default:
+		}
+	}
+}
+
+// Run 实现SocketRunListener
+func (b *Bot) Run(conn net.Channel) {}
+
+func (b *Bot) String() string {
+	return fmt.Sprintf("bot-%d", b.index)
+}
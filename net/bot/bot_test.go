@@ -0,0 +1,198 @@
+package bot
+
+import (
+	"context"
+	"github.com/yhhaiua/engine/buffer"
+	"github.com/yhhaiua/engine/handler"
+	"github.com/yhhaiua/engine/net"
+	gonet "net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// echoListener 回复编号+1的消息，编号2001不回复
+type echoListener struct{}
+
+func (l *echoListener) OnConnected(conn net.Channel) {}
+
+func (l *echoListener) OnDisconnected(conn net.Channel) {
+	conn.Destroy()
+}
+
+func (l *echoListener) OnData(conn net.Channel, msg *buffer.ByteBuf) {
+	req := &Packet{}
+	req.Read(msg)
+	if req.Code == 2001 {
+		return
+	}
+	if req.Code == 3001 {
+		conn.Close()
+		return
+	}
+	net.NewTcpSession(conn).Post(&Packet{Code: req.Code + 1, Body: req.Body})
+}
+
+func freeAddr(t *testing.T) string {
+	ln, err := gonet.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+	return addr
+}
+
+func waitListen(t *testing.T, addr string) {
+	for i := 0; i < 100; i++ {
+		if conn, err := gonet.Dial("tcp", addr); err == nil {
+			_ = conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("listen %s failed", addr)
+}
+
+func TestRunTCP(t *testing.T) {
+	addr := freeAddr(t)
+	server := net.NewTCPServer(addr, &echoListener{})
+	go server.Listen()
+	defer server.Close()
+	waitListen(t, addr)
+
+	scenario := Steps(
+		Step{Send: &Packet{Code: 1001, Body: []byte("login")}, Expect: 1002},
+		Step{Send: &Packet{Code: 1003}},
+		Step{Send: &Packet{Code: 2001}, Expect: 2002, Timeout: 20 * time.Millisecond},
+	)
+	report, err := Run(context.Background(), Config{Addr: addr, Bots: 5, Iterations: 3, RampUp: 50 * time.Millisecond}, scenario)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log("\n" + report.String())
+	if report.Connected != 5 || report.Iterations != 15 || report.ScenarioErrors != 15 {
+		t.Fatalf("report %+v", report)
+	}
+	login, ok := report.Code(1001)
+	if !ok || login.Requests != 15 || login.Errors != 0 || login.P50 <= 0 || login.P99 < login.P50 || login.Throughput <= 0 {
+		t.Fatalf("login %+v", login)
+	}
+	if c, _ := report.Code(1003); c.Sent != 15 || c.Requests != 0 {
+		t.Fatalf("notify %+v", c)
+	}
+	if c, _ := report.Code(2001); c.Requests != 15 || c.Errors != 15 {
+		t.Fatalf("timeout %+v", c)
+	}
+	if report.Received != 30 {
+		t.Fatalf("received %d", report.Received)
+	}
+}
+
+func TestRunWebsocket(t *testing.T) {
+	addr := freeAddr(t)
+	server := net.NewWSServer(addr, &echoListener{}, net.WithCodec(handler.NewWsDecoder, handler.NewWsEncoder()))
+	go server.Listen()
+	defer server.Close()
+	waitListen(t, addr)
+
+	scenario := func(b *Bot) error {
+		_, err := b.Request(&Packet{Code: 1001}, 1002, time.Second)
+		if err != nil {
+			return err
+		}
+		return b.Sleep(10 * time.Millisecond)
+	}
+	var progress int
+	report, err := Run(context.Background(), Config{
+		Addr:             "ws://" + addr + "/",
+		Bots:             4,
+		RampUp:           40 * time.Millisecond,
+		Duration:         200 * time.Millisecond,
+		RampDown:         40 * time.Millisecond,
+		Progress:         func(r *Report) { progress++ },
+		ProgressInterval: 50 * time.Millisecond,
+	}, scenario)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if login, _ := report.Code(1001); report.Connected != 4 || login.Requests < 20 || login.Errors != 0 {
+		t.Fatalf("report %+v", report)
+	}
+	if progress == 0 || report.Elapsed < 240*time.Millisecond {
+		t.Fatalf("progress %d elapsed %s", progress, report.Elapsed)
+	}
+}
+
+// countListener 统计服务器收到的连接数
+type countListener struct {
+	echoListener
+	connected atomic.Int32
+}
+
+func (l *countListener) OnConnected(conn net.Channel) {
+	l.connected.Add(1)
+}
+
+func TestRunWebsocketNoReconnect(t *testing.T) {
+	addr := freeAddr(t)
+	l := &countListener{}
+	server := net.NewWSServer(addr, l, net.WithCodec(handler.NewWsDecoder, handler.NewWsEncoder()))
+	go server.Listen()
+	defer server.Close()
+	waitListen(t, addr)
+
+	//服务器在剧本中途断开机器人，重连等待很短，断开后仍在剧本中
+	scenario := func(b *Bot) error {
+		if _, err := b.Request(&Packet{Code: 1001}, 1002, time.Second); err != nil {
+			return err
+		}
+		b.Send(&Packet{Code: 3001})
+		return b.Sleep(200 * time.Millisecond)
+	}
+	report, err := Run(context.Background(), Config{
+		Addr:       "ws://" + addr + "/",
+		Bots:       1,
+		Iterations: 3,
+		Options:    []net.Option{net.WithReconnect(net.Reconnect{Initial: 10 * time.Millisecond, Jitter: -1})},
+	}, scenario)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Connected != 1 || report.Disconnects != 1 || l.connected.Load() != 1 {
+		t.Fatalf("report %+v server connections %d", report, l.connected.Load())
+	}
+}
+
+func TestRunConnectError(t *testing.T) {
+	report, err := Run(context.Background(), Config{Addr: freeAddr(t), Bots: 2, Iterations: 1}, Steps())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ConnectErrors != 2 || report.Connected != 0 {
+		t.Fatalf("report %+v", report)
+	}
+	if _, err = Run(context.Background(), Config{Bots: 1}, Steps()); err == nil {
+		t.Fatal("config without duration accepted")
+	}
+}
+
+func TestCollectorReportConcurrent(t *testing.T) {
+	c := newCollector()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10000; i++ {
+			c.request(1, time.Duration(10000-i)*time.Microsecond, nil)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		c.report(1, time.Second)
+	}
+	<-done
+	report := c.report(1, time.Second)
+	cr, ok := report.Code(1)
+	if !ok || cr.Requests != 10000 || cr.P50 != 5000*time.Microsecond || cr.Max != 10000*time.Microsecond {
+		t.Fatalf("report %+v", cr)
+	}
+}
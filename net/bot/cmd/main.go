@@ -0,0 +1,104 @@
+// 机器人压测命令，剧本为json文件：
+//
+//	[
+//	  {"send": 1001, "body": "account", "expect": 1002, "timeout": "3s", "think": "200ms"},
+//	  {"send": 1101, "hex": "0000000a", "expect": 1102}
+//	]
+//
+// 消息为4字节大端编号+body（文本）或hex（十六进制），tcp按4字节长度帧编码，websocket每条消息为一个完整消息
+//
+//	go run ./net/bot/cmd -addr 127.0.0.1:9000 -bots 500 -rampup 30s -duration 5m -script login.json
+//	go run ./net/bot/cmd -addr ws://127.0.0.1:9001/ws -bots 100 -iterations 10 -script login.json
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/yhhaiua/engine/net/bot"
+	"os"
+	"os/signal"
+	"time"
+)
+
+type scriptStep struct {
+	Send    int    `json:"send"`
+	Body    string `json:"body"`
+	Hex     string `json:"hex"`
+	Expect  int    `json:"expect"`
+	Timeout string `json:"timeout"`
+	Think   string `json:"think"`
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// loadScript 读取json剧本
+func loadScript(path string) (bot.Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var script []scriptStep
+	if err = json.Unmarshal(data, &script); err != nil {
+		return nil, err
+	}
+	steps := make([]bot.Step, 0, len(script))
+	for i, s := range script {
+		step := bot.Step{Expect: s.Expect}
+		if step.Timeout, err = parseDuration(s.Timeout); err != nil {
+			return nil, fmt.Errorf("step %d timeout: %w", i, err)
+		}
+		if step.Think, err = parseDuration(s.Think); err != nil {
+			return nil, fmt.Errorf("step %d think: %w", i, err)
+		}
+		if s.Send != 0 {
+			body := []byte(s.Body)
+			if s.Hex != "" {
+				if body, err = hex.DecodeString(s.Hex); err != nil {
+					return nil, fmt.Errorf("step %d hex: %w", i, err)
+				}
+			}
+			step.Send = &bot.Packet{Code: s.Send, Body: body}
+		}
+		steps = append(steps, step)
+	}
+	return bot.Steps(steps...), nil
+}
+
+func main() {
+	var config bot.Config
+	var script string
+	flag.StringVar(&config.Addr, "addr", "127.0.0.1:9000", "server address, ws:// or wss:// for websocket")
+	flag.IntVar(&config.Bots, "bots", 10, "number of bots")
+	flag.DurationVar(&config.RampUp, "rampup", 0, "start all bots evenly within this time")
+	flag.DurationVar(&config.Duration, "duration", 0, "run time after all bots started")
+	flag.DurationVar(&config.RampDown, "rampdown", 0, "stop all bots evenly within this time")
+	flag.IntVar(&config.Iterations, "iterations", 0, "scenario runs per bot, 0 for unlimited")
+	flag.DurationVar(&config.ProgressInterval, "progress", 10*time.Second, "progress report interval")
+	flag.StringVar(&script, "script", "", "json scenario file")
+	flag.Parse()
+
+	scenario, err := loadScript(script)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "load script:", err)
+		os.Exit(2)
+	}
+	config.Progress = func(r *bot.Report) {
+		fmt.Println(r.String())
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	report, err := bot.Run(ctx, config, scenario)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	fmt.Println(report.String())
+}
@@ -0,0 +1,194 @@
+package bot
+
+import (
+	"fmt"
+	"github.com/yhhaiua/engine/util"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CodeReport 单个消息编号的统计，延迟只统计Request
+type CodeReport struct {
+	Code       int
+	Sent       int64         //发送次数
+	Requests   int64         //等待回复的请求数（包括失败）
+	Errors     int64         //超时或断开的请求数
+	Throughput float64       //每秒完成的请求数
+	Mean       time.Duration //平均延迟
+	P50        time.Duration
+	P90        time.Duration
+	P99        time.Duration
+	Max        time.Duration
+}
+
+// Report 压测报告
+type Report struct {
+	Bots           int
+	Connected      int   //连接成功的机器人数
+	ConnectErrors  int   //连接失败的机器人数
+	Disconnects    int   //剧本执行中断开的机器人数
+	Iterations     int64 //完成的剧本次数
+	ScenarioErrors int64 //剧本返回错误的次数
+	Sent           int64
+	Received       int64
+	Elapsed        time.Duration
+	Codes          []CodeReport //按编号排序
+}
+
+// Code 查找消息编号的统计
+func (r *Report) Code(code int) (CodeReport, bool) {
+	for _, c := range r.Codes {
+		if c.Code == code {
+			return c, true
+		}
+	}
+	return CodeReport{}, false
+}
+
+func (r *Report) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "bots:%d connected:%d connect_errors:%d disconnects:%d elapsed:%s\n",
+		r.Bots, r.Connected, r.ConnectErrors, r.Disconnects, r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(&sb, "iterations:%d scenario_errors:%d sent:%d received:%d\n",
+		r.Iterations, r.ScenarioErrors, r.Sent, r.Received)
+	fmt.Fprintf(&sb, "%10s %10s %10s %8s %10s %10s %10s %10s %10s %10s\n",
+		"code", "sent", "requests", "errors", "req/s", "mean", "p50", "p90", "p99", "max")
+	for _, c := range r.Codes {
+		fmt.Fprintf(&sb, "%10d %10d %10d %8d %10.1f %10s %10s %10s %10s %10s\n",
+			c.Code, c.Sent, c.Requests, c.Errors, c.Throughput, roundLatency(c.Mean),
+			roundLatency(c.P50), roundLatency(c.P90), roundLatency(c.P99), roundLatency(c.Max))
+	}
+	return sb.String()
+}
+
+func roundLatency(d time.Duration) time.Duration {
+	return d.Round(time.Microsecond)
+}
+
+type codeStats struct {
+	sent      int64
+	requests  int64
+	errors    int64
+	latencies []time.Duration
+}
+
+// collector 所有机器人共享的统计
+type collector struct {
+	mutex          sync.Mutex
+	codes          map[int]*codeStats
+	connected      int
+	connectErrors  int
+	disconnects    int
+	iterations     int64
+	scenarioErrors int64
+	sentCount      util.AtomicLong
+	receivedCount  util.AtomicLong
+}
+
+func newCollector() *collector {
+	return &collector{codes: make(map[int]*codeStats)}
+}
+
+func (c *collector) code(code int) *codeStats {
+	stats, ok := c.codes[code]
+	if !ok {
+		stats = new(codeStats)
+		c.codes[code] = stats
+	}
+	return stats
+}
+
+func (c *collector) sent(code int) {
+	c.sentCount.IncrementAndGet()
+	c.mutex.Lock()
+	c.code(code).sent++
+	c.mutex.Unlock()
+}
+
+func (c *collector) received() {
+	c.receivedCount.IncrementAndGet()
+}
+
+func (c *collector) request(code int, latency time.Duration, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	stats := c.code(code)
+	stats.requests++
+	if err != nil {
+		stats.errors++
+		return
+	}
+	stats.latencies = append(stats.latencies, latency)
+}
+
+func (c *collector) update(fn func(c *collector)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	fn(c)
+}
+
+// codeSnapshot 锁内取出的单个编号统计，延迟切片只追加，截取后可在锁外读取
+type codeSnapshot struct {
+	code  int
+	stats codeStats
+}
+
+func (c *collector) report(bots int, elapsed time.Duration) *Report {
+	c.mutex.Lock()
+	report := &Report{
+		Bots:           bots,
+		Connected:      c.connected,
+		ConnectErrors:  c.connectErrors,
+		Disconnects:    c.disconnects,
+		Iterations:     c.iterations,
+		ScenarioErrors: c.scenarioErrors,
+		Sent:           c.sentCount.Get(),
+		Received:       c.receivedCount.Get(),
+		Elapsed:        elapsed,
+	}
+	snapshots := make([]codeSnapshot, 0, len(c.codes))
+	for code, stats := range c.codes {
+		snapshot := codeSnapshot{code: code, stats: *stats}
+		n := len(stats.latencies)
+		snapshot.stats.latencies = stats.latencies[:n:n]
+		snapshots = append(snapshots, snapshot)
+	}
+	c.mutex.Unlock()
+
+	//复制和排序在锁外进行，不阻塞机器人记录延迟
+	for _, snapshot := range snapshots {
+		stats := snapshot.stats
+		cr := CodeReport{Code: snapshot.code, Sent: stats.sent, Requests: stats.requests, Errors: stats.errors}
+		if n := len(stats.latencies); n > 0 {
+			latencies := append([]time.Duration(nil), stats.latencies...)
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+			var sum time.Duration
+			for _, l := range latencies {
+				sum += l
+			}
+			cr.Mean = sum / time.Duration(n)
+			cr.P50 = percentile(latencies, 0.50)
+			cr.P90 = percentile(latencies, 0.90)
+			cr.P99 = percentile(latencies, 0.99)
+			cr.Max = latencies[n-1]
+			if elapsed > 0 {
+				cr.Throughput = float64(n) / elapsed.Seconds()
+			}
+		}
+		report.Codes = append(report.Codes, cr)
+	}
+	sort.Slice(report.Codes, func(i, j int) bool { return report.Codes[i].Code < report.Codes[j].Code })
+	return report
+}
+
+// percentile 已排序延迟的p分位（最近排名法）
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
@@ -0,0 +1,186 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/yhhaiua/engine/handler"
+	"github.com/yhhaiua/engine/log"
+	"github.com/yhhaiua/engine/net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var logger = log.GetLogger()
+
+// Config 压测配置
+type Config struct {
+	Addr             string          //tcp为host:port，websocket为ws://或wss://开头的地址
+	Bots             int             //机器人数
+	RampUp           time.Duration   //在该时间内均匀启动所有机器人
+	Duration         time.Duration   //全部启动后持续的时间，0时执行完Iterations后结束
+	RampDown         time.Duration   //在该时间内均匀停止所有机器人
+	Iterations       int             //每个机器人执行剧本的次数，0不限制（需设置Duration）
+	Options          []net.Option    //客户端配置（编解码、TLS、加密等），websocket默认使用WsEncoder
+	CodeReader       net.CodeReader  //读取回复的消息编号，默认消息开头4字节大端
+	Progress         func(r *Report) //压测中定时回调当前统计
+	ProgressInterval time.Duration   //Progress回调周期，默认5s
+}
+
+type runner struct {
+	config     *Config
+	scenario   Scenario
+	stats      *collector
+	codeReader net.CodeReader
+}
+
+// Run 按配置启动机器人执行剧本，全部停止后返回报告，ctx取消时立即停止所有机器人
+func Run(ctx context.Context, config Config, scenario Scenario) (*Report, error) {
+	if config.Bots <= 0 {
+		return nil, errors.New("bot: Bots must be positive")
+	}
+	if config.Duration <= 0 && config.Iterations <= 0 {
+		return nil, errors.New("bot: Duration or Iterations required")
+	}
+	r := &runner{config: &config, scenario: scenario, stats: newCollector(), codeReader: config.CodeReader}
+	if r.codeReader == nil {
+		r.codeReader = net.PeekCode
+	}
+	start := time.Now()
+	stopProgress := r.progress(start)
+
+	var wg sync.WaitGroup
+	for i := 0; i < config.Bots; i++ {
+		startAt := start.Add(spread(config.RampUp, i, config.Bots))
+		var stopAt time.Time
+		if config.Duration > 0 {
+			stopAt = start.Add(config.RampUp + config.Duration + spread(config.RampDown, i, config.Bots))
+		}
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			r.runBot(ctx, index, startAt, stopAt)
+		}(i)
+	}
+	wg.Wait()
+	stopProgress()
+	return r.stats.report(config.Bots, time.Since(start)), nil
+}
+
+// spread 第i个机器人在d内的偏移
+func spread(d time.Duration, i, n int) time.Duration {
+	if d <= 0 || n <= 1 {
+		return 0
+	}
+	return d * time.Duration(i) / time.Duration(n)
+}
+
+// progress 定时回调当前统计，返回停止函数，停止后不再回调
+func (r *runner) progress(start time.Time) func() {
+	if r.config.Progress == nil {
+		return func() {}
+	}
+	interval := r.config.ProgressInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	chStop := make(chan struct{})
+	chDone := make(chan struct{})
+	go func() {
+		defer close(chDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.config.Progress(r.stats.report(r.config.Bots, time.Since(start)))
+			case <-chStop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(chStop)
+		<-chDone
+	}
+}
+
+// client 机器人使用的tcp或websocket客户端
+type client interface {
+	Stop()
+}
+
+// connect 连接服务器，失败返回nil
+func (r *runner) connect(b *Bot) client {
+	addr := r.config.Addr
+	if strings.HasPrefix(addr, "ws://") || strings.HasPrefix(addr, "wss://") {
+		//机器人断线后不重连，websocket消息默认不加长度头
+		opts := append([]net.Option{
+			net.WithCodec(handler.NewWsDecoder, handler.NewWsEncoder()),
+		}, r.config.Options...)
+		c := net.NewWsClient(addr, b, opts...)
+		if err := c.Connect(); err != nil {
+			return nil
+		}
+		return c
+	}
+	c := net.NewTCPClient(b.String(), addr, b, r.config.Options...)
+	c.Connect()
+	if c.TcpConn() == nil {
+		return nil
+	}
+	return c
+}
+
+func (r *runner) runBot(parent context.Context, index int, startAt, stopAt time.Time) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if stopAt.IsZero() {
+		ctx, cancel = context.WithCancel(parent)
+	} else {
+		ctx, cancel = context.WithDeadline(parent, stopAt)
+	}
+	defer cancel()
+	b := newBot(index, ctx, r)
+	if b.Sleep(time.Until(startAt)) != nil {
+		return
+	}
+	c := r.connect(b)
+	if c == nil {
+		r.stats.update(func(c *collector) { c.connectErrors++ })
+		return
+	}
+	defer c.Stop()
+	r.stats.update(func(c *collector) { c.connected++ })
+	for i := 0; r.config.Iterations <= 0 || i < r.config.Iterations; i++ {
+		err := r.play(b)
+		if ctx.Err() != nil {
+			return
+		}
+		select {
+		case <-b.lost:
+			logger.Warnf("%s disconnected", b.String())
+			r.stats.update(func(c *collector) { c.disconnects++ })
+			return
+		default:
+		}
+		r.stats.update(func(c *collector) {
+			c.iterations++
+			if err != nil {
+				c.scenarioErrors++
+			}
+		})
+	}
+}
+
+// play 执行一次剧本，捕获剧本中的panic
+func (r *runner) play(b *Bot) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			logger.TraceErr(e)
+			err = fmt.Errorf("bot: scenario panic: %v", e)
+		}
+	}()
+	return r.scenario(b)
+}
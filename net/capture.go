@@ -0,0 +1,307 @@
+//抓包：记录指定连接收发的消息到文件，用于重现客户端问题，录制文件可用Replay回放
+//
+//文件格式：头部"ECAP" + 1字节版本 + 8字节大端开始时间（unix纳秒），
+//之后每条记录为1字节类型 + uvarint连接编号 + uvarint距开始的纳秒数 + uvarint长度 + 内容
+
+package net
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"maps"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	captureVersion       = 1
+	captureFlushInterval = time.Second
+	captureMaxData       = 64 << 20
+)
+
+var captureMagic = []byte("ECAP")
+
+// CaptureKind 录制记录类型
+type CaptureKind byte
+
+const (
+	CaptureConnect    CaptureKind = iota + 1 //连接建立，内容为ip
+	CaptureInbound                           //收到的消息（解码后，即OnData收到的内容）
+	CaptureOutbound                          //发送的消息（WriteAndFlush写入的已编码数据）
+	CaptureDisconnect                        //连接断开
+)
+
+var errCaptureFormat = errors.New("net.Capture: invalid capture file")
+
+// Capture 抓包录制，只记录Watch/WatchIp选中的连接，可在运行中随时增减，多个连接共享。
+// 未选中的连接只读取选中列表的快照，不加锁
+type Capture struct {
+	mutex     sync.Mutex
+	w         *bufio.Writer
+	closer    io.Closer
+	start     time.Time
+	lastFlush time.Time
+	filter    atomic.Pointer[captureFilter]
+	announced sync.Map //已写入连接记录的连接
+	scratch   []byte
+	err       error
+}
+
+// captureFilter 选中的连接，修改时复制
+type captureFilter struct {
+	all bool
+	ids map[int64]struct{}
+	ips map[string]struct{}
+}
+
+func (f *captureFilter) match(conn Channel) bool {
+	if f.all {
+		return true
+	}
+	if _, ok := f.ids[conn.Id()]; ok {
+		return true
+	}
+	if len(f.ips) == 0 {
+		return false
+	}
+	_, ok := f.ips[conn.Ip()]
+	return ok
+}
+
+// NewCapture 录制到w，需调用Flush或Close写出缓冲的数据
+func NewCapture(w io.Writer) (*Capture, error) {
+	c := &Capture{
+		w:     bufio.NewWriter(w),
+		start: time.Now(),
+	}
+	c.lastFlush = c.start
+	c.filter.Store(&captureFilter{ids: map[int64]struct{}{}, ips: map[string]struct{}{}})
+	header := make([]byte, 0, len(captureMagic)+9)
+	header = append(header, captureMagic...)
+	header = append(header, captureVersion)
+	header = binary.BigEndian.AppendUint64(header, uint64(c.start.UnixNano()))
+	if _, err := c.w.Write(header); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// CreateCapture 录制到文件，Close时关闭文件
+func CreateCapture(path string) (*Capture, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewCapture(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	c.closer = f
+	return c, nil
+}
+
+// update 复制选中列表修改后替换
+func (c *Capture) update(fn func(f *captureFilter)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	old := c.filter.Load()
+	f := &captureFilter{all: old.all, ids: maps.Clone(old.ids), ips: maps.Clone(old.ips)}
+	fn(f)
+	c.filter.Store(f)
+}
+
+// Watch 录制连接编号
+func (c *Capture) Watch(id int64) {
+	c.update(func(f *captureFilter) { f.ids[id] = struct{}{} })
+}
+
+// Unwatch 停止录制连接编号
+func (c *Capture) Unwatch(id int64) {
+	c.update(func(f *captureFilter) { delete(f.ids, id) })
+}
+
+// WatchIp 录制来自ip的连接
+func (c *Capture) WatchIp(ip string) {
+	c.update(func(f *captureFilter) { f.ips[ip] = struct{}{} })
+}
+
+// UnwatchIp 停止录制来自ip的连接
+func (c *Capture) UnwatchIp(ip string) {
+	c.update(func(f *captureFilter) { delete(f.ips, ip) })
+}
+
+// WatchAll 录制所有连接（用于测试环境）
+func (c *Capture) WatchAll(all bool) {
+	c.update(func(f *captureFilter) { f.all = all })
+}
+
+// record 连接选中时写入记录，第一次写入连接的记录前先写入连接记录，未选中的连接不加锁
+func (c *Capture) record(conn Channel, kind CaptureKind, data []byte) {
+	if c == nil {
+		return
+	}
+	id := conn.Id()
+	if kind == CaptureDisconnect {
+		if _, announced := c.announced.LoadAndDelete(id); !announced {
+			return
+		}
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if c.err == nil {
+			c.write(id, kind, nil)
+			c.flush()
+		}
+		return
+	}
+	if !c.filter.Load().match(conn) {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.err != nil {
+		return
+	}
+	now := time.Now()
+	if _, announced := c.announced.LoadOrStore(id, struct{}{}); !announced {
+		c.write(id, CaptureConnect, []byte(conn.Ip()))
+	}
+	if kind != CaptureConnect {
+		c.write(id, kind, data)
+	}
+	if now.Sub(c.lastFlush) >= captureFlushInterval {
+		c.flush()
+	}
+}
+
+func (c *Capture) write(id int64, kind CaptureKind, data []byte) {
+	b := append(c.scratch[:0], byte(kind))
+	b = binary.AppendUvarint(b, uint64(id))
+	b = binary.AppendUvarint(b, uint64(time.Since(c.start)))
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	c.scratch = b
+	if _, err := c.w.Write(b); err != nil {
+		c.fail(err)
+		return
+	}
+	if _, err := c.w.Write(data); err != nil {
+		c.fail(err)
+	}
+}
+
+func (c *Capture) flush() {
+	c.lastFlush = time.Now()
+	if err := c.w.Flush(); err != nil {
+		c.fail(err)
+	}
+}
+
+// fail 写入出错后停止录制
+func (c *Capture) fail(err error) {
+	logger.Errorf("capture write err,stop capture: %s", err.Error())
+	c.err = err
+}
+
+// Flush 写出缓冲的数据
+func (c *Capture) Flush() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	c.flush()
+	return c.err
+}
+
+// Close 写出缓冲的数据并停止录制，CreateCapture创建时关闭文件
+func (c *Capture) Close() error {
+	err := c.Flush()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.err == nil {
+		c.err = errors.New("net.Capture: closed")
+	}
+	if c.closer != nil {
+		if e := c.closer.Close(); err == nil {
+			err = e
+		}
+		c.closer = nil
+	}
+	return err
+}
+
+// CaptureRecord 录制的一条记录
+type CaptureRecord struct {
+	Kind   CaptureKind
+	ConnId int64
+	Time   time.Time
+	Data   []byte //连接记录为ip
+}
+
+// CaptureReader 读取录制文件
+type CaptureReader struct {
+	r     *bufio.Reader
+	start time.Time
+}
+
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(captureMagic)+9)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, errCaptureFormat
+	}
+	if string(header[:len(captureMagic)]) != string(captureMagic) || header[len(captureMagic)] != captureVersion {
+		return nil, errCaptureFormat
+	}
+	start := int64(binary.BigEndian.Uint64(header[len(captureMagic)+1:]))
+	return &CaptureReader{r: br, start: time.Unix(0, start)}, nil
+}
+
+// Start 开始录制的时间
+func (r *CaptureReader) Start() time.Time {
+	return r.start
+}
+
+// Next 读取下一条记录，结束时返回io.EOF，文件不完整（进程异常退出）时返回io.ErrUnexpectedEOF
+func (r *CaptureReader) Next() (*CaptureRecord, error) {
+	kind, err := r.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	id, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	elapsed, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	n, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if kind < byte(CaptureConnect) || kind > byte(CaptureDisconnect) || n > captureMaxData {
+		return nil, errCaptureFormat
+	}
+	data := make([]byte, n)
+	if _, err = io.ReadFull(r.r, data); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return &CaptureRecord{
+		Kind:   CaptureKind(kind),
+		ConnId: int64(id),
+		Time:   r.start.Add(time.Duration(elapsed)),
+		Data:   data,
+	}, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,170 @@
+package net
+
+import (
+	"bytes"
+	"context"
+	"github.com/yhhaiua/engine/buffer"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCaptureReplay(t *testing.T) {
+	var file bytes.Buffer
+	capture, err := NewCapture(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo := func(conn Channel, msg *buffer.ByteBuf) {
+		conn.WriteAndFlush(frame(append([]byte("re:"), msg.Bytes()...)))
+	}
+	chFirst := make(chan Channel, 1)
+	l := &testListener{onData: func(conn Channel, msg *buffer.ByteBuf) {
+		if string(msg.Bytes()) == "a" {
+			chFirst <- conn
+		}
+		echo(conn, msg)
+	}}
+	addr := freeAddr(t)
+	server := NewTCPServer(addr, l, WithCapture(capture))
+	go server.Listen()
+	defer server.Close()
+
+	watched := dialRetry(t, addr)
+	other := dialRetry(t, addr)
+	defer other.Close()
+	waitFor(t, func() bool { return l.connectedCount() == 2 })
+	//只录制第一个连接
+	_, _ = watched.Write(frame([]byte("a")))
+	watchedId := (<-chFirst).Id()
+	capture.Watch(watchedId)
+	readFrame(t, watched)
+	_, _ = watched.Write(frame([]byte("b")))
+	readFrame(t, watched)
+	time.Sleep(30 * time.Millisecond)
+	_, _ = watched.Write(frame([]byte("c")))
+	readFrame(t, watched)
+	_, _ = other.Write(frame([]byte("x")))
+	readFrame(t, other)
+	_ = watched.Close()
+	waitFor(t, func() bool { return l.disconnectedCount() == 1 })
+	if err = capture.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rl := &testListener{onData: echo}
+	start := time.Now()
+	conns, err := Replay(context.Background(), bytes.NewReader(file.Bytes()), rl, ReplayOptions{Timing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 25*time.Millisecond {
+		t.Fatal("original timing not kept")
+	}
+	conn, ok := conns[watchedId]
+	if !ok || len(conns) != 1 || conn.Ip() != "127.0.0.1" {
+		t.Fatalf("conns %v", conns)
+	}
+	if rl.connectedCount() != 1 || rl.disconnectedCount() != 1 || rl.dataCount() != 2 ||
+		string(rl.data[0]) != "b" || string(rl.data[1]) != "c" {
+		t.Fatalf("replayed %q", rl.data)
+	}
+	written, recorded := conn.Written(), conn.Recorded()
+	if len(recorded) != 2 || len(written) != 2 || !bytes.Equal(written[1], recorded[1]) {
+		t.Fatalf("written %q recorded %q", written, recorded)
+	}
+}
+
+func TestCaptureReaderTruncated(t *testing.T) {
+	var file bytes.Buffer
+	capture, _ := NewCapture(&file)
+	capture.WatchAll(true)
+	c := newTestChannel()
+	capture.record(c, CaptureInbound, []byte("hello"))
+	capture.record(c, CaptureInbound, []byte("world"))
+	_ = capture.Flush()
+
+	//进程异常退出时最后一条记录不完整
+	data := file.Bytes()[:file.Len()-2]
+	reader, err := NewCaptureReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []CaptureKind
+	for {
+		record, err := reader.Next()
+		if err != nil {
+			if err.Error() != "unexpected EOF" {
+				t.Fatal(err)
+			}
+			break
+		}
+		kinds = append(kinds, record.Kind)
+	}
+	if len(kinds) != 2 || kinds[0] != CaptureConnect || kinds[1] != CaptureInbound {
+		t.Fatalf("kinds %v", kinds)
+	}
+	if _, err = NewCaptureReader(bytes.NewReader([]byte("nope"))); err == nil {
+		t.Fatal("invalid header accepted")
+	}
+}
+
+func TestCaptureConcurrentWatch(t *testing.T) {
+	var file bytes.Buffer
+	capture, _ := NewCapture(&file)
+	conns := []*testChannel{newTestChannel(), newTestChannel(), newTestChannel()}
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(1)
+		go func(c *testChannel) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				capture.record(c, CaptureInbound, []byte("in"))
+				capture.record(c, CaptureOutbound, []byte("out"))
+			}
+			capture.record(c, CaptureDisconnect, nil)
+		}(c)
+	}
+	for i := 0; i < 100; i++ {
+		capture.Watch(conns[0].Id())
+		capture.WatchIp("10.0.0.1")
+		capture.Unwatch(conns[0].Id())
+	}
+	capture.Watch(conns[1].Id())
+	wg.Wait()
+	_ = capture.Close()
+
+	//每个连接的记录以连接记录开始、断开记录结束，未选中的连接没有记录
+	reader, err := NewCaptureReader(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := make(map[int64]bool)
+	for {
+		record, err := reader.Next()
+		if err != nil {
+			break
+		}
+		if record.ConnId == conns[2].Id() {
+			t.Fatal("unwatched conn recorded")
+		}
+		switch record.Kind {
+		case CaptureConnect:
+			open[record.ConnId] = true
+		case CaptureDisconnect:
+			if !open[record.ConnId] {
+				t.Fatal("disconnect before connect")
+			}
+			open[record.ConnId] = false
+		default:
+			if !open[record.ConnId] {
+				t.Fatal("record before connect")
+			}
+		}
+	}
+	for id, o := range open {
+		if o {
+			t.Fatalf("conn %d not closed", id)
+		}
+	}
+}
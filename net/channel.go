@@ -1,5 +1,9 @@
 package net
 
+import (
+	"github.com/yhhaiua/engine/handler"
+)
+
 type Channel interface {
 	//WriteAndFlush 向目标发送数据
 	WriteAndFlush(msg []byte)
@@ -12,3 +16,17 @@ type Channel interface {
 	//Id 连接编号
 	Id() int64
 }
+
+// EncoderChannel 连接使用的消息编码器
+type EncoderChannel interface {
+	Channel
+	Encoder() handler.Encoder
+}
+
+// channelEncoder 获取连接的编码器，未实现EncoderChannel时使用默认编码
+func channelEncoder(channel Channel) handler.Encoder {
+	if c, ok := channel.(EncoderChannel); ok {
+		return c.Encoder()
+	}
+	return defaultEncoder
+}
@@ -0,0 +1,234 @@
+package net
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/yhhaiua/engine/buffer"
+	"github.com/yhhaiua/engine/handler"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPacket 测试消息：编号+字符串
+type testPacket struct {
+	code int
+	text string
+}
+
+func (p *testPacket) Write(buf *buffer.ByteBuf) {
+	buf.WriteNInt32(int32(p.code))
+	buf.WriteNString(p.text)
+}
+
+func (p *testPacket) Read(buf *buffer.ByteBuf) {
+	p.code = int(buf.ReadNInt32())
+	p.text = buf.ReadNString()
+}
+
+func (p *testPacket) Copy() buffer.PacketCons {
+	return &testPacket{code: p.code}
+}
+
+func (p *testPacket) CodeId() int {
+	return p.code
+}
+
+func (p *testPacket) Module() string {
+	return "test"
+}
+
+func (p *testPacket) GetStage() int {
+	return 0
+}
+
+func TestTCPServerCustomCodec(t *testing.T) {
+	encoder, err := handler.NewLengthEncoderField(binary.LittleEndian, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newHandler := func() (handler.Handler, error) {
+		return handler.NewLengthDecoderField(binary.LittleEndian, 1024, 2)
+	}
+	l := &testListener{}
+	l.onData = func(conn Channel, msg *buffer.ByteBuf) {
+		p := &testPacket{}
+		p.Read(msg)
+		p.text += "!"
+		NewTcpSession(conn).Post(p)
+	}
+	addr := freeAddr(t)
+	server := NewTCPServer(addr, l, WithCodec(newHandler, encoder))
+	go server.Listen()
+	defer server.Close()
+	conn := dialRetry(t, addr)
+	defer conn.Close()
+
+	_, _ = conn.Write(encoder.Encode(&testPacket{code: 7, text: "hi"}))
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	head := make([]byte, 2)
+	if _, err = io.ReadFull(conn, head); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, binary.LittleEndian.Uint16(head))
+	if _, err = io.ReadFull(conn, body); err != nil {
+		t.Fatal(err)
+	}
+	p := &testPacket{}
+	p.Read(buffer.NewBuffer(body))
+	if p.code != 7 || p.text != "hi!" {
+		t.Fatalf("got %+v", p)
+	}
+}
+
+func TestCompressors(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 100000)
+	r.Read(random)
+	repeat := bytes.Repeat([]byte("bag item 1001 x99;"), 5000)
+	for _, c := range []handler.Compressor{handler.Deflate, handler.Zlib, handler.Snappy} {
+		for _, src := range [][]byte{nil, []byte("short"), random, repeat, append(repeat[:70000:70000], random[:40000]...)} {
+			out, err := c.Compress(nil, src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := c.Decompress(out, len(src))
+			if err != nil || !bytes.Equal(got, src) {
+				t.Fatalf("algorithm %d: round trip failed for %d bytes: %v", c.Id(), len(src), err)
+			}
+			if len(src) > 0 {
+				//超过最大长度
+				if _, err := c.Decompress(out, len(src)-1); err == nil {
+					t.Fatalf("algorithm %d: max size not checked", c.Id())
+				}
+			}
+		}
+		out, _ := c.Compress(nil, repeat)
+		if len(out) > len(repeat)/10 {
+			t.Fatalf("algorithm %d: ratio %d/%d", c.Id(), len(out), len(repeat))
+		}
+		if _, err := c.Decompress(out[:len(out)/2], len(repeat)); err == nil {
+			t.Fatalf("algorithm %d: truncated input accepted", c.Id())
+		}
+	}
+}
+
+func TestSnappyVectors(t *testing.T) {
+	//按snappy格式规范手工构造：长度varint + 字面量/copy标签
+	decode := []struct {
+		in, want string
+	}{
+		{"\x00", ""},
+		{"\x03\x08\xff\xff\xff", "\xff\xff\xff"},
+		{"\x08\x0cabcd\x01\x04", "abcdabcd"},                                          //copy1
+		{"\x08\x0cabcd\x0e\x04\x00", "abcdabcd"},                                      //copy2
+		{"\x08\x0cabcd\x0f\x04\x00\x00\x00", "abcdabcd"},                              //copy4
+		{"\x0a\x00a\x15\x01", "aaaaaaaaaa"},                                           //重叠copy
+		{"\x3d\xf0\x3c" + strings.Repeat("x", 61), strings.Repeat("x", 61)},           //1字节长度的字面量
+		{"\x80\x02\xf4\xff\x00" + strings.Repeat("y", 256), strings.Repeat("y", 256)}, //2字节长度的字面量
+	}
+	for _, v := range decode {
+		got, err := handler.Snappy.Decompress([]byte(v.in), len(v.want))
+		if err != nil || string(got) != v.want {
+			t.Fatalf("decode %q: got %q %v", v.in, got, err)
+		}
+	}
+	for _, in := range []string{"\x08\x0cabcd\x01\x05", "\x08\x0cabcd\x01\x04\x00", "\x09\x0cabcd\x01\x04", "\x04\x0cab"} {
+		if _, err := handler.Snappy.Decompress([]byte(in), 16); err == nil {
+			t.Fatalf("corrupt %q accepted", in)
+		}
+	}
+	encode := []struct {
+		in, want string
+	}{
+		{"", "\x00"},
+		{"abc", "\x03\x08abc"},
+		{strings.Repeat("a", 20), "\x14\x00a\x4a\x01\x00"}, //字面量a + copy2（偏移1长度19）
+	}
+	for _, v := range encode {
+		got, _ := handler.Snappy.Compress(nil, []byte(v.in))
+		if string(got) != v.want {
+			t.Fatalf("encode %q: got %q want %q", v.in, got, v.want)
+		}
+	}
+}
+
+func TestCompressionInvalid(t *testing.T) {
+	for _, config := range []handler.Compression{
+		{Threshold: -1},
+		{Threshold: 2048, MaxFrameLength: 1024},
+		{MaxFrameLength: -1},
+	} {
+		if option, err := WithCompression(config); err == nil || option != nil {
+			t.Fatalf("config %+v accepted", config)
+		}
+	}
+	if _, err := WithCompression(handler.Compression{Threshold: 1024, MaxFrameLength: 1024}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompressFlagLength(t *testing.T) {
+	for _, c := range []struct {
+		n, max int
+		ok     bool
+	}{
+		{1, 0, false},
+		{1, 127, true},
+		{1, 128, false},
+		{2, 32767, true},
+		{2, 32768, false},
+		{4, 0, true},
+	} {
+		config := handler.Compression{MaxFrameLength: c.max}
+		_, encErr := handler.NewCompressEncoder(binary.BigEndian, c.n, config)
+		_, decErr := handler.NewCompressDecoder(binary.BigEndian, c.n, config)
+		if (encErr == nil) != c.ok || (decErr == nil) != c.ok {
+			t.Fatalf("length %d max %d: encoder %v decoder %v", c.n, c.max, encErr, decErr)
+		}
+	}
+}
+
+func TestPipeCompression(t *testing.T) {
+	for _, c := range []handler.Compressor{handler.Deflate, handler.Zlib, handler.Snappy} {
+		stats := handler.NewCompressStats()
+		option, err := WithCompression(handler.Compression{Algorithm: c, Threshold: 64, Stats: stats})
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := &testListener{}
+		l.onData = func(conn Channel, msg *buffer.ByteBuf) {
+			p := &testPacket{}
+			p.Read(msg)
+			NewTcpSession(conn).Post(p)
+		}
+		server := NewPipeServer(l, option)
+		cl := &testListener{}
+		client, err := server.Dial(cl, option)
+		if err != nil {
+			t.Fatal(err)
+		}
+		big := strings.Repeat("rank:1001,score:99999;", 200)
+		session := NewTcpSession(client)
+		session.Post(&testPacket{code: 1, text: "small"})
+		session.Post(&testPacket{code: 2, text: big})
+		waitFor(t, func() bool { return cl.dataCount() == 2 })
+		for i, want := range []string{"small", big} {
+			p := &testPacket{}
+			p.Read(buffer.NewBuffer(cl.data[i]))
+			if p.text != want {
+				t.Fatalf("algorithm %d: message %d mismatch", c.Id(), i)
+			}
+		}
+		snapshot := stats.Snapshot()
+		if s := snapshot[1]; s.Frames != 2 || s.Compressed != 0 {
+			t.Fatalf("small stats %+v", s)
+		}
+		if s := snapshot[2]; s.Compressed != 2 || s.Decompressed != 2 || s.Ratio() >= 0.2 || s.CompressTime <= 0 {
+			t.Fatalf("big stats %+v ratio %f", s, s.Ratio())
+		}
+		client.Close()
+	}
+}
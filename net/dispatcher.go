@@ -0,0 +1,116 @@
+package net
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/yhhaiua/engine/buffer"
+	"strconv"
+)
+
+// ErrUnknownCode 未注册的消息编号
+var ErrUnknownCode = errors.New("net.Dispatcher: unknown code")
+
+// PacketHandler 消息处理函数，cmd为注册原型Copy后读取的消息
+type PacketHandler func(session *TcpSession, cmd buffer.PacketCons)
+
+// CodeReader 从消息中读取消息编号
+type CodeReader func(msg *buffer.ByteBuf) (int, error)
+
+// DispatchErrorHandler 消息分发出错处理，code未读取到时为0
+type DispatchErrorHandler func(conn Channel, code int, err error)
+
+type packetEntry struct {
+	prototype buffer.PacketCons
+	handler   PacketHandler
+}
+
+// Dispatcher 按消息编号分发消息，可嵌入SocketListener实现中直接作为OnData使用
+// 所有注册需在服务启动前完成
+type Dispatcher struct {
+	entries    map[int]*packetEntry
+	codeReader CodeReader
+	onError    DispatchErrorHandler
+}
+
+// NewDispatcher 新建消息分发器，默认读取消息开头4字节大端编号（不移动读取位置）
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		entries:    make(map[int]*packetEntry),
+		codeReader: PeekCode,
+		onError:    logDispatchError,
+	}
+}
+
+// PeekCode 读取消息开头4字节大端编号，不移动读取位置，消息Read时自行读取编号
+func PeekCode(msg *buffer.ByteBuf) (int, error) {
+	if msg.ReadableBytes() < 4 {
+		return 0, errors.New("net.Dispatcher: message too short: " + strconv.Itoa(msg.ReadableBytes()))
+	}
+	return int(int32(msg.GetUnsignedInt(msg.ReaderIndex(), binary.BigEndian))), nil
+}
+
+func logDispatchError(conn Channel, code int, err error) {
+	logger.Errorf("dispatch err: %s,code:%d,Ip:%s", err.Error(), code, conn.Ip())
+}
+
+// Register 注册消息原型和处理函数，编号取自prototype.CodeId()
+func (d *Dispatcher) Register(prototype buffer.PacketCons, handler PacketHandler) {
+	code := prototype.CodeId()
+	if _, ok := d.entries[code]; ok {
+		logger.Warnf("dispatcher code repeat register:%d", code)
+	}
+	d.entries[code] = &packetEntry{prototype: prototype, handler: handler}
+}
+
+// SetCodeReader 自定义消息编号读取
+func (d *Dispatcher) SetCodeReader(reader CodeReader) {
+	d.codeReader = reader
+}
+
+// SetErrorHandler 未知编号和解析异常的处理，默认记录日志
+func (d *Dispatcher) SetErrorHandler(handler DispatchErrorHandler) {
+	d.onError = handler
+}
+
+// Prototype 获取已注册的消息原型
+func (d *Dispatcher) Prototype(code int) (buffer.PacketCons, bool) {
+	entry, ok := d.entries[code]
+	if !ok {
+		return nil, false
+	}
+	return entry.prototype, true
+}
+
+// OnData 读取编号，解析消息并调用处理函数
+func (d *Dispatcher) OnData(conn Channel, msg *buffer.ByteBuf) {
+	code, err := d.codeReader(msg)
+	if err != nil {
+		d.onError(conn, 0, err)
+		return
+	}
+	entry, ok := d.entries[code]
+	if !ok {
+		d.onError(conn, code, ErrUnknownCode)
+		return
+	}
+	cmd, err := d.decode(entry, msg)
+	if err != nil {
+		d.onError(conn, code, err)
+		return
+	}
+	entry.handler(NewTcpSession(conn), cmd)
+}
+
+// decode 解析消息，读取越界等异常转为错误
+func (d *Dispatcher) decode(entry *packetEntry, msg *buffer.ByteBuf) (cmd buffer.PacketCons, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			cmd = nil
+			err = fmt.Errorf("net.Dispatcher: decode panic: %v", r)
+		}
+	}()
+	cmd = entry.prototype.Copy()
+	cmd.Read(msg)
+	return cmd, nil
+}
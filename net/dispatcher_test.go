@@ -0,0 +1,62 @@
+package net
+
+import (
+	"github.com/yhhaiua/engine/buffer"
+	"testing"
+)
+
+// panicPacket 读取时越界
+type panicPacket struct {
+	testPacket
+}
+
+func (p *panicPacket) Read(buf *buffer.ByteBuf) {
+	p.testPacket.Read(buf)
+	_ = buf.Bytes()[buf.ReadableBytes()+10]
+}
+
+func (p *panicPacket) Copy() buffer.PacketCons {
+	return &panicPacket{testPacket{code: p.code}}
+}
+
+func TestDispatcher(t *testing.T) {
+	d := NewDispatcher()
+	var got *testPacket
+	d.Register(&testPacket{code: 1}, func(session *TcpSession, cmd buffer.PacketCons) {
+		got = cmd.(*testPacket)
+		session.Post(&testPacket{code: 2, text: "ack"})
+	})
+	d.Register(&panicPacket{testPacket{code: 3}}, func(session *TcpSession, cmd buffer.PacketCons) {
+		t.Fatal("handler called after decode panic")
+	})
+	var errCodes []int
+	var errs []error
+	d.SetErrorHandler(func(conn Channel, code int, err error) {
+		errCodes = append(errCodes, code)
+		errs = append(errs, err)
+	})
+
+	conn := newTestChannel()
+	encode := func(p *testPacket) *buffer.ByteBuf {
+		msg := buffer.NewByteBuf()
+		p.Write(msg)
+		return msg
+	}
+	d.OnData(conn, encode(&testPacket{code: 1, text: "hello"}))
+	if got == nil || got.text != "hello" || conn.writeCount() != 1 {
+		t.Fatalf("dispatch failed: %+v", got)
+	}
+
+	d.OnData(conn, encode(&testPacket{code: 9}))
+	d.OnData(conn, encode(&testPacket{code: 3, text: "x"}))
+	d.OnData(conn, buffer.NewBuffer([]byte{1}))
+	if len(errs) != 3 {
+		t.Fatalf("errors %v", errs)
+	}
+	if errs[0] != ErrUnknownCode || errCodes[0] != 9 {
+		t.Fatalf("unknown code: %v %d", errs[0], errCodes[0])
+	}
+	if errCodes[1] != 3 || errCodes[2] != 0 {
+		t.Fatalf("codes %v", errCodes)
+	}
+}
@@ -14,3 +14,30 @@ type SocketRunListener interface {
 	SocketListener
 	Run(conn Channel)
 }
+
+// IdleState 空闲类型
+type IdleState int
+
+const (
+	ReaderIdle IdleState = iota + 1 //读空闲
+	WriterIdle                      //写空闲
+)
+
+// SocketIdleListener 连接空闲通知，未实现时读空闲直接断开连接
+type SocketIdleListener interface {
+	SocketListener
+	OnIdle(conn Channel, state IdleState)
+}
+
+// defaultIdle 未实现SocketIdleListener时的处理
+func defaultIdle(conn Channel, state IdleState) {
+	if state != ReaderIdle {
+		return
+	}
+	logger.Infof("read idle close:%s", conn.Ip())
+	if c, ok := conn.(reasonCloser); ok {
+		c.closeWith(DisconnectIdle)
+	} else if c, ok := conn.(connCloser); ok {
+		c.close()
+	}
+}
@@ -0,0 +1,71 @@
+package net
+
+import (
+	"github.com/yhhaiua/engine/buffer"
+	"github.com/yhhaiua/engine/util"
+	"github.com/yhhaiua/engine/util/queue"
+)
+
+// mailbox 连接消息串行执行队列，同一连接的消息按顺序执行，不同连接之间并发执行
+type mailbox struct {
+	queue   *queue.DefaultQueue[func()]
+	pending util.AtomicInteger
+	limit   int
+}
+
+func newMailbox(limit int) *mailbox {
+	m := &mailbox{limit: limit}
+	m.queue = queue.NewDefault[func()](m.run)
+	return m
+}
+
+func (m *mailbox) run(task func()) {
+	defer func() {
+		m.pending.DecrementAndGet()
+		//异常不能中断队列，否则后续消息无法执行
+		if r := recover(); r != nil {
+			logger.TraceErr(r)
+		}
+	}()
+	task()
+}
+
+// post 投递消息处理，force为false时超过队列限制返回false
+func (m *mailbox) post(task func(), force bool) bool {
+	count := int(m.pending.IncrementAndGet())
+	if !force && m.limit > 0 && count > m.limit {
+		m.pending.DecrementAndGet()
+		return false
+	}
+	m.queue.Add(task)
+	return true
+}
+
+// Pending 等待执行的消息数量
+func (m *mailbox) Pending() int {
+	return m.pending.Get()
+}
+
+// dispatchData 通知收到消息，开启串行执行时复制数据后投递到队列，队列已满返回false
+func dispatchData(m *mailbox, listener SocketListener, conn Channel, msg *buffer.ByteBuf) bool {
+	if m == nil {
+		listener.OnData(conn, msg)
+		return true
+	}
+	//解码出的消息与接收缓冲区共用内存，异步处理前需复制
+	data := msg.NewSlice(msg.ReaderIndex(), msg.ReadableBytes())
+	if !m.post(func() { listener.OnData(conn, data) }, false) {
+		logger.Errorf("mailbox full,close:%s,len:%d", conn.Ip(), m.Pending())
+		return false
+	}
+	return true
+}
+
+// dispatchDisconnected 通知断开连接，开启串行执行时在已投递的消息之后执行
+func dispatchDisconnected(m *mailbox, listener SocketListener, conn Channel) {
+	if m == nil {
+		listener.OnDisconnected(conn)
+		return
+	}
+	m.post(func() { listener.OnDisconnected(conn) }, true)
+}
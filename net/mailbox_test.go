@@ -0,0 +1,64 @@
+package net
+
+import (
+	"github.com/yhhaiua/engine/buffer"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSerialExecutorOrder(t *testing.T) {
+	l := &testListener{}
+	l.onData = func(conn Channel, msg *buffer.ByteBuf) {
+		time.Sleep(time.Millisecond)
+	}
+	addr := freeAddr(t)
+	server := NewTCPServer(addr, l, WithSerialExecutor(0))
+	go server.Listen()
+	defer server.Close()
+	conn := dialRetry(t, addr)
+
+	const count = 50
+	for i := 0; i < count; i++ {
+		_, _ = conn.Write(frame([]byte(strconv.Itoa(i))))
+	}
+	_ = conn.Close()
+	waitFor(t, func() bool { return l.disconnectedCount() == 1 })
+	//断开通知在所有消息处理之后
+	if l.dataCount() != count {
+		t.Fatalf("data %d", l.dataCount())
+	}
+	for i, data := range l.data {
+		if string(data) != strconv.Itoa(i) {
+			t.Fatalf("order %d: %q", i, data)
+		}
+	}
+}
+
+func TestSerialExecutorLimit(t *testing.T) {
+	l := &testListener{}
+	block := make(chan struct{})
+	l.onData = func(conn Channel, msg *buffer.ByteBuf) {
+		<-block
+	}
+	addr := freeAddr(t)
+	server := NewTCPServer(addr, l, WithSerialExecutor(2))
+	go server.Listen()
+	defer server.Close()
+	conn := dialRetry(t, addr)
+	defer conn.Close()
+
+	for i := 0; i < 10; i++ {
+		_, _ = conn.Write(frame([]byte("flood")))
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	close(block)
+	waitFor(t, func() bool { return l.disconnectedCount() == 1 })
+	if l.dataCount() > 3 {
+		t.Fatalf("data %d", l.dataCount())
+	}
+}
@@ -0,0 +1,278 @@
+package net
+
+import (
+	"encoding/binary"
+	"github.com/yhhaiua/engine/buffer"
+	"github.com/yhhaiua/engine/util"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxTrafficCodes 按消息编号统计的最大编号数，防止异常客户端发送随机编号占用内存，超过后只计入总数
+const maxTrafficCodes = 4096
+
+// DisconnectReason 连接断开原因
+type DisconnectReason int
+
+const (
+	DisconnectClosed       DisconnectReason = iota + 1 //本端关闭（Close、Kick、Destroy、服务器关闭）
+	DisconnectRemote                                   //对方关闭
+	DisconnectReadError                                //读出错
+	DisconnectWriteError                               //写出错或超时
+	DisconnectDecodeError                              //解码出错
+	DisconnectRateLimit                                //超过接收速率限制
+	DisconnectMailboxFull                              //串行执行队列已满
+	DisconnectBackpressure                             //写入队列已满
+	DisconnectIdle                                     //读空闲超时或udp连接超时
+	disconnectReasonCount
+)
+
+func (r DisconnectReason) String() string {
+	switch r {
+	case DisconnectClosed:
+		return "closed"
+	case DisconnectRemote:
+		return "remote"
+	case DisconnectReadError:
+		return "read_error"
+	case DisconnectWriteError:
+		return "write_error"
+	case DisconnectDecodeError:
+		return "decode_error"
+	case DisconnectRateLimit:
+		return "rate_limit"
+	case DisconnectMailboxFull:
+		return "mailbox_full"
+	case DisconnectBackpressure:
+		return "backpressure"
+	case DisconnectIdle:
+		return "idle"
+	default:
+		return "unknown(" + strconv.Itoa(int(r)) + ")"
+	}
+}
+
+// ConnStats 单个连接的流量统计，BytesIn为从连接读取的字节数，FramesIn为解码出的消息数
+type ConnStats struct {
+	Id             int64
+	Ip             string
+	ConnectedAt    time.Time
+	BytesIn        int64
+	FramesIn       int64
+	BytesOut       int64
+	FramesOut      int64
+	DecodeErrors   int64
+	QueueLen       int //当前写入队列长度
+	QueueHighWater int //写入队列最大长度
+}
+
+// Duration 连接时长
+func (s ConnStats) Duration() time.Duration {
+	return time.Since(s.ConnectedAt)
+}
+
+// StatsChannel 支持流量统计的连接
+type StatsChannel interface {
+	Channel
+	Stats() ConnStats
+}
+
+// CodeTraffic 单个消息编号的接收统计
+type CodeTraffic struct {
+	Frames int64
+	Bytes  int64
+}
+
+// TrafficStats 服务器所有连接的流量汇总
+type TrafficStats struct {
+	Connects       int64 //建立的连接数
+	BytesIn        int64
+	FramesIn       int64
+	BytesOut       int64
+	FramesOut      int64
+	DecodeErrors   int64
+	QueueHighWater int                        //所有连接写入队列的最大长度
+	Disconnects    map[DisconnectReason]int64 //按原因统计的断开数
+	Codes          map[int]CodeTraffic        //按消息编号（消息开头4字节大端）统计的接收
+}
+
+// TopCodes 接收字节数最多的n个消息编号
+func (s TrafficStats) TopCodes(n int) []int {
+	codes := make([]int, 0, len(s.Codes))
+	for code := range s.Codes {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		return s.Codes[codes[i]].Bytes > s.Codes[codes[j]].Bytes
+	})
+	if len(codes) > n {
+		codes = codes[:n]
+	}
+	return codes
+}
+
+type codeCounter struct {
+	frames util.AtomicLong
+	bytes  util.AtomicLong
+}
+
+// trafficCounter 所有连接的流量汇总，保存在Options中
+type trafficCounter struct {
+	connects       util.AtomicLong
+	bytesIn        util.AtomicLong
+	framesIn       util.AtomicLong
+	bytesOut       util.AtomicLong
+	framesOut      util.AtomicLong
+	decodeErrors   util.AtomicLong
+	queueHighWater util.AtomicInteger
+	disconnects    [disconnectReasonCount]util.AtomicLong
+	codes          sync.Map
+	codeCount      util.AtomicInteger
+}
+
+func (c *trafficCounter) code(code, bytes int) {
+	v, ok := c.codes.Load(code)
+	if !ok {
+		if c.codeCount.Get() >= maxTrafficCodes {
+			return
+		}
+		var loaded bool
+		if v, loaded = c.codes.LoadOrStore(code, new(codeCounter)); !loaded {
+			c.codeCount.IncrementAndGet()
+		}
+	}
+	counter := v.(*codeCounter)
+	counter.frames.IncrementAndGet()
+	counter.bytes.AddAndGet(int64(bytes))
+}
+
+func (c *trafficCounter) snapshot() TrafficStats {
+	stats := TrafficStats{
+		Connects:       c.connects.Get(),
+		BytesIn:        c.bytesIn.Get(),
+		FramesIn:       c.framesIn.Get(),
+		BytesOut:       c.bytesOut.Get(),
+		FramesOut:      c.framesOut.Get(),
+		DecodeErrors:   c.decodeErrors.Get(),
+		QueueHighWater: c.queueHighWater.Get(),
+		Disconnects:    make(map[DisconnectReason]int64),
+		Codes:          make(map[int]CodeTraffic),
+	}
+	for i := range c.disconnects {
+		if n := c.disconnects[i].Get(); n > 0 {
+			stats.Disconnects[DisconnectReason(i)] = n
+		}
+	}
+	c.codes.Range(func(key, value any) bool {
+		counter := value.(*codeCounter)
+		stats.Codes[key.(int)] = CodeTraffic{Frames: counter.frames.Get(), Bytes: counter.bytes.Get()}
+		return true
+	})
+	return stats
+}
+
+// connMetrics 单个连接的流量统计，同时计入Options中的汇总
+type connMetrics struct {
+	total        *trafficCounter
+	writes       *writeCounter //汇总的批量写入统计
+	connectedAt  time.Time
+	bytesIn      util.AtomicLong
+	framesIn     util.AtomicLong
+	bytesOut     util.AtomicLong
+	framesOut    util.AtomicLong
+	decodeErrors util.AtomicLong
+	reason       util.AtomicInteger
+}
+
+func (m *connMetrics) init(opts *Options) {
+	m.total = &opts.traffic
+	m.writes = &opts.writeStats
+	m.connectedAt = time.Now()
+}
+
+// start 连接开始服务时计入汇总的连接数
+func (m *connMetrics) start() {
+	m.total.connects.IncrementAndGet()
+}
+
+// reject 超过连接数被拒绝的连接只有自己的统计，不计入汇总
+func (m *connMetrics) reject() {
+	m.total = new(trafficCounter)
+	m.writes = new(writeCounter)
+}
+
+// read 从连接读取的字节数
+func (m *connMetrics) read(n int) {
+	if n <= 0 {
+		return
+	}
+	m.bytesIn.AddAndGet(int64(n))
+	m.total.bytesIn.AddAndGet(int64(n))
+}
+
+// frame 解码出一条消息
+func (m *connMetrics) frame(msg *buffer.ByteBuf) {
+	m.framesIn.IncrementAndGet()
+	m.total.framesIn.IncrementAndGet()
+	n := msg.ReadableBytes()
+	if n >= 4 {
+		m.total.code(int(int32(binary.BigEndian.Uint32(msg.Bytes()))), n)
+	}
+}
+
+// write 写入frames条消息共bytes字节
+func (m *connMetrics) write(frames, bytes int) {
+	m.framesOut.AddAndGet(int64(frames))
+	m.bytesOut.AddAndGet(int64(bytes))
+	m.total.framesOut.AddAndGet(int64(frames))
+	m.total.bytesOut.AddAndGet(int64(bytes))
+}
+
+// batch 一次批量写入messages条消息共bytes字节
+func (m *connMetrics) batch(messages, bytes int) {
+	m.writes.add(messages, bytes)
+}
+
+// queued 写入后队列长度为n，更新汇总的队列最大长度
+func (m *connMetrics) queued(n int32) {
+	total := &m.total.queueHighWater
+	for {
+		old := total.Get()
+		if int(n) <= old || total.CompareAndSet(int32(old), n) {
+			return
+		}
+	}
+}
+
+func (m *connMetrics) decodeError() {
+	m.decodeErrors.IncrementAndGet()
+	m.total.decodeErrors.IncrementAndGet()
+}
+
+// disconnect 记录断开原因，只记录第一个
+func (m *connMetrics) disconnect(reason DisconnectReason) {
+	m.reason.CompareAndSet(0, int32(reason))
+}
+
+// closed 连接关闭时计入汇总，未记录原因时为本端关闭
+func (m *connMetrics) closed() {
+	m.reason.CompareAndSet(0, int32(DisconnectClosed))
+	m.total.disconnects[m.reason.Get()].IncrementAndGet()
+}
+
+func (m *connMetrics) snapshot(id int64, ip string, queue *writeQueue) ConnStats {
+	return ConnStats{
+		Id:             id,
+		Ip:             ip,
+		ConnectedAt:    m.connectedAt,
+		BytesIn:        m.bytesIn.Get(),
+		FramesIn:       m.framesIn.Get(),
+		BytesOut:       m.bytesOut.Get(),
+		FramesOut:      m.framesOut.Get(),
+		DecodeErrors:   m.decodeErrors.Get(),
+		QueueLen:       len(queue.chData),
+		QueueHighWater: queue.highWater.Get(),
+	}
+}
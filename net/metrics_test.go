@@ -0,0 +1,86 @@
+package net
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// codeFrame 按4字节长度头封包，内容为4字节编号+body
+func codeFrame(code int32, body []byte) []byte {
+	b := make([]byte, 4+len(body))
+	binary.BigEndian.PutUint32(b, uint32(code))
+	copy(b[4:], body)
+	return frame(b)
+}
+
+func TestTrafficStats(t *testing.T) {
+	l := &testListener{}
+	addr := freeAddr(t)
+	server := NewTCPServerLength(addr, l, 1024)
+	go server.Listen()
+	defer server.Close()
+
+	heavy := dialRetry(t, addr)
+	light := dialRetry(t, addr)
+	defer light.Close()
+	for i := 0; i < 10; i++ {
+		_, _ = heavy.Write(codeFrame(101, make([]byte, 100)))
+	}
+	_, _ = light.Write(codeFrame(102, nil))
+	waitFor(t, func() bool { return l.dataCount() == 11 })
+
+	top := server.Registry().TopConns(1, nil)
+	if len(top) != 1 || top[0].FramesIn != 10 || top[0].BytesIn != 10*108 {
+		t.Fatalf("top %+v", top)
+	}
+	heavyConn, _ := server.Registry().Get(top[0].Id)
+	server.Registry().Range(func(conn Channel) bool {
+		if conn != heavyConn {
+			conn.WriteAndFlush(frame([]byte("pong")))
+		}
+		return true
+	})
+	if string(readFrame(t, light)) != "pong" {
+		t.Fatal("pong not received")
+	}
+
+	//对方关闭
+	_ = heavy.Close()
+	waitFor(t, func() bool { return l.disconnectedCount() == 1 })
+	if reason := heavyConn.(*TCPConn).DisconnectReason(); reason != DisconnectRemote {
+		t.Fatalf("reason %s", reason)
+	}
+	//超过最大长度解码出错
+	_, _ = light.Write(frame(make([]byte, 2048)))
+	waitFor(t, func() bool { return l.disconnectedCount() == 2 })
+
+	stats := server.TrafficStats()
+	if stats.Connects != 2 || stats.FramesIn != 11 || stats.FramesOut != 1 || stats.DecodeErrors != 1 {
+		t.Fatalf("stats %+v", stats)
+	}
+	if stats.Disconnects[DisconnectRemote] != 1 || stats.Disconnects[DisconnectDecodeError] != 1 {
+		t.Fatalf("disconnects %v", stats.Disconnects)
+	}
+	if stats.Codes[101] != (CodeTraffic{Frames: 10, Bytes: 1040}) || stats.Codes[102].Frames != 1 {
+		t.Fatalf("codes %v", stats.Codes)
+	}
+	if codes := stats.TopCodes(1); len(codes) != 1 || codes[0] != 101 {
+		t.Fatalf("top codes %v", codes)
+	}
+}
+
+func TestQueueHighWater(t *testing.T) {
+	opts := newOptions()
+	var metrics connMetrics
+	metrics.init(opts)
+	q := newWriteQueue(8, opts, &metrics)
+	c := newTestChannel()
+	for i := 0; i < 5; i++ {
+		q.push(c, []byte{1})
+	}
+	<-q.chData
+	q.push(c, []byte{1})
+	if q.highWater.Get() != 5 || opts.traffic.queueHighWater.Get() != 5 {
+		t.Fatalf("high water %d", q.highWater.Get())
+	}
+}
@@ -0,0 +1,237 @@
+package net
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"github.com/yhhaiua/engine/handler"
+	"time"
+)
+
+// defaultEncoder 默认4字节大端长度编码
+var defaultEncoder = handler.NewLengthEncoder()
+
+// Options 连接配置，由服务器或客户端创建的所有连接共享
+type Options struct {
+	ReadIdle      time.Duration                           //读空闲超时，超时未收到数据触发ReaderIdle，0不检测
+	WriteIdle     time.Duration                           //写空闲超时，超时未发送数据触发WriterIdle并发送心跳包，0不检测
+	Heartbeat     []byte                                  //写空闲时发送的心跳包（已编码的完整帧）
+	PingPeriod    time.Duration                           //websocket ping周期
+	PongWait      time.Duration                           //websocket 等待pong超时
+	TLSConfig     *tls.Config                             //不为空时使用TLS（websocket为wss）
+	NewHandler    handler.NewHandler                      //为每个连接创建解码器，为空时tcp使用4字节长度解码，websocket使用WsDecoder
+	Encoder       handler.Encoder                         //消息编码，为空时使用4字节长度编码
+	Serial        bool                                    //OnData和OnDisconnected在连接自己的队列中串行执行，不占用读协程
+	MailboxLimit  int                                     //串行执行时队列中等待的最大消息数，超过后断开连接，0不限制
+	RateLimit     *RateLimit                              //每个连接的接收速率限制，为空不限制
+	MaxConns      int                                     //服务器最大连接数，0不限制
+	MaxConnsPerIP int                                     //单个ip最大连接数，0不限制，unix socket（未开启PROXY protocol）和内存管道没有ip，不限制
+	OnReject      func(conn Channel, reason RejectReason) //拒绝连接时回调，可发送"服务器已满"消息，之后连接关闭；开启加密时在握手前拒绝，不回调
+	MaxBatchBytes int                                     //tcp单次批量写入的最大字节数，0不合并写入
+	writeStats    writeCounter                            //所有连接的批量写入统计
+	QueueSize     int                                     //每个连接的写入队列容量，0使用默认值
+	Backpressure  Backpressure                            //写入队列已满时的处理，默认一直阻塞等待
+	backpressure  backpressureCounter                     //所有连接的写入队列已满统计
+	traffic       trafficCounter                          //所有连接的流量统计
+	Reconnect     Reconnect                               //客户端断线重连配置
+	DialTimeout   time.Duration                           //tcp客户端连接超时（包括TLS握手），默认10s，0不限制
+	WsDialer      WsDialer                                //websocket客户端拨号配置
+	WsUpgrader    WsUpgrader                              //websocket服务器握手配置
+	UDP           UDPConfig                               //可靠udp配置
+	ProxyProtocol *ProxyProtocol                          //tcp服务器解析PROXY protocol头，为空不解析
+	Network       string                                  //tcp服务器和客户端的网络类型，tcp（默认）或unix（addr为socket文件路径）
+	Secure        *Secure                                 //tcp和websocket加密通道，为空不加密
+	Capture       *Capture                                //tcp和websocket抓包录制，为空不录制
+}
+
+// Option 修改连接配置
+type Option func(o *Options)
+
+func newOptions(opts ...Option) *Options {
+	o := &Options{
+		PingPeriod:    pingPeriod,
+		PongWait:      pongWait,
+		MaxBatchBytes: defaultMaxBatchBytes,
+		Network:       "tcp",
+		DialTimeout:   defaultDialTimeout,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// encoder 连接使用的编码器
+func (o *Options) encoder() handler.Encoder {
+	if o.Encoder != nil {
+		return o.Encoder
+	}
+	return defaultEncoder
+}
+
+// WithReadIdle 读空闲超时
+func WithReadIdle(d time.Duration) Option {
+	return func(o *Options) {
+		o.ReadIdle = d
+	}
+}
+
+// WithWriteIdle 写空闲超时
+func WithWriteIdle(d time.Duration) Option {
+	return func(o *Options) {
+		o.WriteIdle = d
+	}
+}
+
+// WithHeartbeat 写空闲超时后发送的心跳包
+func WithHeartbeat(d time.Duration, msg []byte) Option {
+	return func(o *Options) {
+		o.WriteIdle = d
+		o.Heartbeat = msg
+	}
+}
+
+// WithPing websocket ping周期和等待pong超时，period需小于wait
+func WithPing(period, wait time.Duration) Option {
+	return func(o *Options) {
+		o.PingPeriod = period
+		o.PongWait = wait
+	}
+}
+
+// WithTLS 使用TLS加密传输，服务端需配置证书，客户端需配置根证书或ServerName
+func WithTLS(config *tls.Config) Option {
+	return func(o *Options) {
+		o.TLSConfig = config
+	}
+}
+
+// WithCodec 自定义解码器和编码器，不同端口可使用不同的协议格式
+func WithCodec(newHandler handler.NewHandler, encoder handler.Encoder) Option {
+	return func(o *Options) {
+		o.NewHandler = newHandler
+		o.Encoder = encoder
+	}
+}
+
+// WithSerialExecutor 每个连接的消息在独立队列中串行执行，不同连接之间并发执行，
+// limit为队列中等待的最大消息数，超过后断开连接，0不限制
+func WithSerialExecutor(limit int) Option {
+	return func(o *Options) {
+		o.Serial = true
+		o.MailboxLimit = limit
+	}
+}
+
+// WithRateLimit 每个连接的接收速率限制
+func WithRateLimit(limit RateLimit) Option {
+	return func(o *Options) {
+		o.RateLimit = &limit
+	}
+}
+
+// WithConnLimit 服务器最大连接数和单个ip最大连接数，超出时调用onReject后关闭连接
+func WithConnLimit(maxConns, maxConnsPerIP int, onReject func(conn Channel, reason RejectReason)) Option {
+	return func(o *Options) {
+		o.MaxConns = maxConns
+		o.MaxConnsPerIP = maxConnsPerIP
+		o.OnReject = onReject
+	}
+}
+
+// WithMaxBatchBytes tcp单次批量写入的最大字节数，0不合并写入
+func WithMaxBatchBytes(n int) Option {
+	return func(o *Options) {
+		o.MaxBatchBytes = n
+	}
+}
+
+// WithQueueSize 每个连接的写入队列容量
+func WithQueueSize(size int) Option {
+	return func(o *Options) {
+		o.QueueSize = size
+	}
+}
+
+// WithBackpressure 写入队列已满（慢消费者）时的处理策略，timeout为BackpressureBlock的等待超时
+func WithBackpressure(policy BackpressurePolicy, timeout time.Duration, onFull func(conn Channel, action BackpressurePolicy)) Option {
+	return func(o *Options) {
+		o.Backpressure = Backpressure{Policy: policy, Timeout: timeout, OnFull: onFull}
+	}
+}
+
+// WithReconnect 客户端断线重连配置
+func WithReconnect(reconnect Reconnect) Option {
+	return func(o *Options) {
+		o.Reconnect = reconnect
+	}
+}
+
+// WithDialTimeout tcp客户端连接超时，0不限制
+func WithDialTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.DialTimeout = d
+	}
+}
+
+// WithWsDialer websocket客户端拨号配置
+func WithWsDialer(dialer WsDialer) Option {
+	return func(o *Options) {
+		o.WsDialer = dialer
+	}
+}
+
+// WithWsUpgrader websocket服务器握手配置（消息长度、Origin、压缩、子协议、鉴权）
+func WithWsUpgrader(upgrader WsUpgrader) Option {
+	return func(o *Options) {
+		o.WsUpgrader = upgrader
+	}
+}
+
+// WithUDP 可靠udp配置，可使用UDPNormal、UDPFast后修改
+func WithUDP(config UDPConfig) Option {
+	return func(o *Options) {
+		o.UDP = config
+	}
+}
+
+// WithNetwork tcp服务器和客户端的网络类型，同一主机上的进程可使用unix
+func WithNetwork(network string) Option {
+	return func(o *Options) {
+		o.Network = network
+	}
+}
+
+// WithProxyProtocol tcp服务器在负载均衡后时解析PROXY protocol头
+func WithProxyProtocol(p ProxyProtocol) Option {
+	return func(o *Options) {
+		o.ProxyProtocol = &p
+	}
+}
+
+// WithCompression 4字节大端长度帧，超过阈值的消息压缩，两端需一致，配置无效时返回错误
+func WithCompression(compression handler.Compression) (Option, error) {
+	encoder, err := handler.NewCompressEncoder(binary.BigEndian, 4, compression)
+	if err != nil {
+		return nil, err
+	}
+	return func(o *Options) {
+		o.Encoder = encoder
+		o.NewHandler = func() (handler.Handler, error) {
+			return handler.NewCompressDecoder(binary.BigEndian, 4, compression)
+		}
+	}, nil
+}
+
+// WithSecure 连接建立后交换密钥，之后每帧加密，两端需一致
+func WithSecure(secure Secure) Option {
+	return func(o *Options) {
+		o.Secure = &secure
+	}
+}
+
+// WithCapture 录制选中连接收发的消息，服务器的所有连接共享同一个Capture
+func WithCapture(capture *Capture) Option {
+	return func(o *Options) {
+		o.Capture = capture
+	}
+}
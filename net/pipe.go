@@ -0,0 +1,79 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"github.com/yhhaiua/engine/util"
+	"net"
+)
+
+// ErrPipeClosed 内存服务器已关闭
+var ErrPipeClosed = errors.New("pipe server closed")
+
+// PipeServer 内存传输服务器，不占用端口，Dial通过net.Pipe创建一对已连接的TCPConn，
+// 两端与tcp一样回调各自的SocketListener，适合同进程通信和单元测试
+type PipeServer struct {
+	listener SocketListener
+	length   int
+	hub      *serverListener
+	opts     *Options
+	closing  util.AtomicInteger
+}
+
+func NewPipeServer(listener SocketListener, opts ...Option) *PipeServer {
+	return NewPipeServerLength(listener, 327670, opts...)
+}
+func NewPipeServerLength(listener SocketListener, length int, opts ...Option) *PipeServer {
+	o := newOptions(opts...)
+	server := &PipeServer{
+		listener: listener,
+		length:   length,
+		hub:      newServerListener(listener, o),
+		opts:     o,
+	}
+	return server
+}
+
+// Dial 创建一个连接，服务器端回调服务器的listener，返回的客户端连接回调传入的listener。
+// 超过连接数限制时与tcp一样拒绝（服务器端发送OnReject的消息后关闭）
+func (server *PipeServer) Dial(listener SocketListener, opts ...Option) (*TCPConn, error) {
+	if server.closing.Get() == 1 {
+		return nil, ErrPipeClosed
+	}
+	serverConn, clientConn := net.Pipe()
+	client := newTcpConn(clientConn, listener, server.length, newOptions(opts...))
+	if client == nil {
+		_ = serverConn.Close()
+		_ = clientConn.Close()
+		return nil, errCreateConn
+	}
+	conn := newTcpConn(serverConn, server.hub, server.length, server.opts)
+	if conn == nil {
+		_ = serverConn.Close()
+		_ = clientConn.Close()
+		return nil, errCreateConn
+	}
+	client.start()
+	if reason, ok := server.hub.admit(conn.Ip()); !ok {
+		go conn.reject(reason)
+		return client, nil
+	}
+	conn.start()
+	return client, nil
+}
+
+// Close 不再接受新连接
+func (server *PipeServer) Close() {
+	server.closing.Set(1)
+}
+
+// Shutdown 优雅关闭：停止接收新连接，通知所有连接并发送完队列中的数据，等待连接全部断开或ctx超时
+func (server *PipeServer) Shutdown(ctx context.Context) error {
+	server.Close()
+	return server.hub.shutdown(ctx)
+}
+
+// Registry 存活连接管理
+func (server *PipeServer) Registry() *Registry {
+	return server.hub.registry
+}
@@ -0,0 +1,72 @@
+package net
+
+import (
+	"github.com/yhhaiua/engine/buffer"
+	"testing"
+)
+
+func TestPipeServer(t *testing.T) {
+	l := &testListener{}
+	l.onData = func(conn Channel, msg *buffer.ByteBuf) {
+		conn.WriteAndFlush(frame(msg.Bytes()))
+	}
+	server := NewPipeServer(l)
+	cl := &testListener{}
+	client, err := server.Dial(cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return server.Registry().Count() == 1 })
+	if cl.connectedCount() != 1 || client.Ip() != "pipe" {
+		t.Fatalf("connected %d ip %s", cl.connectedCount(), client.Ip())
+	}
+	client.WriteAndFlush(frame([]byte("echo")))
+	waitFor(t, func() bool { return cl.dataCount() == 1 })
+	if string(cl.data[0]) != "echo" {
+		t.Fatalf("got %q", cl.data[0])
+	}
+
+	client.Close()
+	waitFor(t, func() bool { return l.disconnectedCount() == 1 && cl.disconnectedCount() == 1 })
+	if server.Registry().Count() != 0 {
+		t.Fatal("conn not removed")
+	}
+	server.Close()
+	if _, err := server.Dial(cl); err != ErrPipeClosed {
+		t.Fatalf("err %v", err)
+	}
+}
+
+func TestPipeServerReject(t *testing.T) {
+	l := &testListener{}
+	server := NewPipeServer(l, WithConnLimit(1, 0, func(conn Channel, reason RejectReason) {
+		conn.WriteAndFlush(frame([]byte("full")))
+	}))
+	if _, err := server.Dial(&testListener{}); err != nil {
+		t.Fatal(err)
+	}
+	cl := &testListener{}
+	if _, err := server.Dial(cl); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return cl.disconnectedCount() == 1 })
+	if cl.dataCount() != 1 || string(cl.data[0]) != "full" || l.connectedCount() != 1 {
+		t.Fatal("second conn not rejected")
+	}
+}
+
+func TestPipeServerPerIP(t *testing.T) {
+	//内存管道没有ip，单个ip连接数限制不生效
+	l := &testListener{}
+	server := NewPipeServer(l, WithConnLimit(0, 1, nil))
+	defer server.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := server.Dial(&testListener{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, func() bool { return l.connectedCount() == 3 })
+	if server.Registry().Count() != 3 {
+		t.Fatalf("count %d", server.Registry().Count())
+	}
+}
@@ -0,0 +1,163 @@
+package net
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultProxyTimeout = 5 * time.Second
+	proxyV1MaxLength    = 107 //v1头最大长度（包含\r\n）
+)
+
+var (
+	proxyV1Prefix  = []byte("PROXY ")
+	proxyV2Sign    = []byte("\r\n\r\n\x00\r\nQUIT\n")
+	errProxyHeader = errors.New("invalid proxy protocol header")
+	//未配置来源时任何客户端都可以伪造ip，绕过ip封禁和单ip连接数限制
+	errProxyTrusted = errors.New("proxy protocol requires trusted source CIDRs")
+)
+
+// ProxyProtocol 解析负载均衡发送的HAProxy PROXY protocol头（v1文本和v2二进制），
+// 之后Channel.Ip返回原始客户端地址
+type ProxyProtocol struct {
+	Trusted []string      //允许发送PROXY头的来源（负载均衡）CIDR，如10.0.0.0/8，tcp必须配置，信任所有来源需显式配置0.0.0.0/0和::/0；unix socket信任所有连接，不需要配置
+	Timeout time.Duration //等待PROXY头超时，默认5s
+}
+
+// proxyParser 解析后的配置
+type proxyParser struct {
+	trusted []netip.Prefix
+	unix    bool //unix socket只有本机有权限的进程能连接（本机的负载均衡），信任所有连接
+	timeout time.Duration
+}
+
+func newProxyParser(p *ProxyProtocol, network string) (*proxyParser, error) {
+	unix := network == "unix"
+	if len(p.Trusted) == 0 && !unix {
+		return nil, errProxyTrusted
+	}
+	parser := &proxyParser{unix: unix, timeout: p.Timeout}
+	if parser.timeout <= 0 {
+		parser.timeout = defaultProxyTimeout
+	}
+	for _, cidr := range p.Trusted {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		parser.trusted = append(parser.trusted, prefix.Masked())
+	}
+	return parser, nil
+}
+
+// trust 来源地址是否允许发送PROXY头
+func (p *proxyParser) trust(addr net.Addr) bool {
+	if _, ok := addr.(*net.UnixAddr); ok {
+		return p.unix
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip, ok := netip.AddrFromSlice(tcpAddr.IP)
+	if !ok {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range p.trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// read 读取PROXY头，只读取头部不多读，返回原始客户端地址，LOCAL/UNKNOWN（如负载均衡健康检查）返回nil
+func (p *proxyParser) read(conn net.Conn) (net.Addr, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(p.timeout))
+	defer conn.SetReadDeadline(time.Time{})
+	//v1最短的"PROXY UNKNOWN\r\n"也超过12字节
+	header := make([]byte, len(proxyV2Sign))
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	if bytes.Equal(header, proxyV2Sign) {
+		return readProxyV2(conn)
+	}
+	if bytes.HasPrefix(header, proxyV1Prefix) {
+		return readProxyV1(conn, header)
+	}
+	return nil, errProxyHeader
+}
+
+// readProxyV1 PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func readProxyV1(conn net.Conn, header []byte) (net.Addr, error) {
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(header, []byte("\r\n")) {
+		if len(header) >= proxyV1MaxLength {
+			return nil, errProxyHeader
+		}
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return nil, err
+		}
+		header = append(header, b[0])
+	}
+	fields := strings.Fields(string(header[:len(header)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errProxyHeader
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, errProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// readProxyV2 12字节签名后为版本命令、协议族、2字节长度和地址
+func readProxyV2(conn net.Conn) (net.Addr, error) {
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return nil, err
+	}
+	if head[0]>>4 != 2 {
+		return nil, errProxyHeader
+	}
+	body := make([]byte, binary.BigEndian.Uint16(head[2:]))
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return nil, err
+	}
+	switch head[0] & 0x0f {
+	case 0x0: //LOCAL
+		return nil, nil
+	case 0x1: //PROXY
+	default:
+		return nil, errProxyHeader
+	}
+	switch head[1] >> 4 {
+	case 0x1: //IPv4
+		if len(body) < 12 {
+			return nil, errProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:]))}, nil
+	case 0x2: //IPv6
+		if len(body) < 36 {
+			return nil, errProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:]))}, nil
+	default:
+		//UNSPEC、unix socket，使用连接地址
+		return nil, nil
+	}
+}
@@ -0,0 +1,132 @@
+package net
+
+import (
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func proxyV2Header(cmd byte, src net.IP, port int) []byte {
+	header := append([]byte(nil), proxyV2Sign...)
+	var body []byte
+	fam := byte(0x11)
+	if ip4 := src.To4(); ip4 != nil {
+		body = append(body, ip4...)
+		body = append(body, 127, 0, 0, 1)
+	} else {
+		fam = 0x21
+		body = append(body, src.To16()...)
+		body = append(body, net.IPv6loopback...)
+	}
+	body = binary.BigEndian.AppendUint16(body, uint16(port))
+	body = binary.BigEndian.AppendUint16(body, 443)
+	header = append(header, 0x20|cmd, fam)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(body)))
+	return append(header, body...)
+}
+
+func TestProxyProtocol(t *testing.T) {
+	l := &testListener{}
+	addr := freeAddr(t)
+	server := NewTCPServer(addr, l, WithProxyProtocol(ProxyProtocol{Trusted: []string{"127.0.0.0/8"}}))
+	go server.Listen()
+	defer server.Close()
+	dialRetry(t, addr).Close()
+
+	for i, c := range []struct {
+		header []byte
+		ip     string
+	}{
+		{[]byte("PROXY TCP4 203.0.113.7 127.0.0.1 56324 443\r\n"), "203.0.113.7"},
+		{[]byte("PROXY TCP6 2001:db8::1 ::1 56324 443\r\n"), "2001:db8::1"},
+		{[]byte("PROXY UNKNOWN\r\n"), "127.0.0.1"},
+		{proxyV2Header(1, net.ParseIP("198.51.100.9"), 5000), "198.51.100.9"},
+		{proxyV2Header(1, net.ParseIP("2001:db8::2"), 5000), "2001:db8::2"},
+		{proxyV2Header(0, net.ParseIP("198.51.100.9"), 5000), "127.0.0.1"},
+	} {
+		conn := dialRetry(t, addr)
+		//头部和第一帧在同一个包中，不能多读
+		_, _ = conn.Write(append(c.header, frame([]byte("hello"))...))
+		waitFor(t, func() bool { return l.dataCount() == i+1 })
+		if got := l.connected[i].Ip(); got != c.ip {
+			t.Fatalf("case %d: ip %s want %s", i, got, c.ip)
+		}
+		if string(l.data[i]) != "hello" {
+			t.Fatalf("case %d: data %q", i, l.data[i])
+		}
+		conn.Close()
+	}
+
+	//无效的头部断开连接
+	conn := dialRetry(t, addr)
+	defer conn.Close()
+	_, _ = conn.Write(frame([]byte("no proxy header")))
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+		t.Fatalf("expected close, got %v", err)
+	}
+}
+
+func TestProxyProtocolUntrusted(t *testing.T) {
+	l := &testListener{}
+	addr := freeAddr(t)
+	server := NewTCPServer(addr, l, WithProxyProtocol(ProxyProtocol{Trusted: []string{"10.0.0.0/8"}}))
+	go server.Listen()
+	defer server.Close()
+	conn := dialRetry(t, addr)
+	defer conn.Close()
+	_, _ = conn.Write([]byte("PROXY TCP4 203.0.113.7 127.0.0.1 56324 443\r\n"))
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+		t.Fatalf("untrusted source not closed: %v", err)
+	}
+	if l.connectedCount() != 0 {
+		t.Fatal("untrusted source connected")
+	}
+}
+
+func TestProxyProtocolRequiresTrusted(t *testing.T) {
+	if _, err := newProxyParser(&ProxyProtocol{}, "tcp"); err != errProxyTrusted {
+		t.Fatalf("err %v", err)
+	}
+	//未配置来源时不监听
+	addr := freeAddr(t)
+	server := NewTCPServer(addr, &testListener{}, WithProxyProtocol(ProxyProtocol{}))
+	server.Listen()
+	if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		conn.Close()
+		t.Fatal("server listening without trusted sources")
+	}
+	//显式信任所有来源
+	if _, err := newProxyParser(&ProxyProtocol{Trusted: []string{"0.0.0.0/0", "::/0"}}, "tcp"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProxyProtocolUnixSocket(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "proxy.sock")
+	l := &testListener{}
+	server := NewTCPServer(addr, l, WithNetwork("unix"), WithProxyProtocol(ProxyProtocol{}))
+	go server.Listen()
+	defer server.Close()
+	var conn net.Conn
+	waitFor(t, func() bool {
+		var err error
+		conn, err = net.Dial("unix", addr)
+		return err == nil
+	})
+	defer conn.Close()
+	//本机负载均衡通过unix socket转发，不需要配置来源
+	_, _ = conn.Write(append([]byte("PROXY TCP4 203.0.113.7 127.0.0.1 56324 443\r\n"), frame([]byte("hello"))...))
+	waitFor(t, func() bool { return l.dataCount() == 1 })
+	if got := l.connected[0].Ip(); got != "203.0.113.7" {
+		t.Fatalf("ip %s", got)
+	}
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
@@ -0,0 +1,155 @@
+package net
+
+import (
+	"time"
+)
+
+// LimitPolicy 超过速率限制后的处理方式
+type LimitPolicy int
+
+const (
+	LimitDrop  LimitPolicy = iota //丢弃超出的消息
+	LimitDelay                    //暂停读取直到令牌足够，依靠tcp流控减慢客户端（websocket等待后延长读超时）
+	LimitClose                    //断开连接
+)
+
+// RateLimit 每个连接的接收速率限制（令牌桶）
+type RateLimit struct {
+	Messages      int                                              //每秒消息数，0不限制
+	MessagesBurst int                                              //消息突发上限，0时等于Messages
+	Bytes         int                                              //每秒字节数，0不限制
+	BytesBurst    int                                              //字节突发上限，0时等于Bytes，需不小于单条消息最大长度，超过的消息任何策略下都断开连接
+	Policy        LimitPolicy                                      //超出后的处理
+	OnLimit       func(conn Channel, policy LimitPolicy, size int) //超出时回调，可记录日志和封禁
+}
+
+// tokenBucket 令牌桶，只在连接读协程中使用，不加锁
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = rate
+	}
+	return &tokenBucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// enough 令牌是否足够
+func (b *tokenBucket) enough(now time.Time, n float64) bool {
+	if b == nil {
+		return true
+	}
+	b.refill(now)
+	return b.tokens >= n
+}
+
+func (b *tokenBucket) take(n float64) {
+	if b != nil {
+		b.tokens -= n
+	}
+}
+
+// reserve 预支令牌，返回令牌足够前需要等待的时间
+func (b *tokenBucket) reserve(now time.Time, n float64) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.refill(now)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// delayChannel 限速等待后需要延长读超时的连接（websocket等待期间无法读取pong）
+type delayChannel interface {
+	extendReadDeadline()
+}
+
+// rateLimiter 连接接收限速
+type rateLimiter struct {
+	limit    *RateLimit
+	messages *tokenBucket
+	bytes    *tokenBucket
+	now      func() time.Time
+	sleep    func(d time.Duration)
+}
+
+func newRateLimiter(limit *RateLimit) *rateLimiter {
+	if limit == nil || (limit.Messages <= 0 && limit.Bytes <= 0) {
+		return nil
+	}
+	return &rateLimiter{
+		limit:    limit,
+		messages: newTokenBucket(limit.Messages, limit.MessagesBurst),
+		bytes:    newTokenBucket(limit.Bytes, limit.BytesBurst),
+		now:      time.Now,
+		sleep:    time.Sleep,
+	}
+}
+
+// check 检查收到的消息，返回是否处理该消息和是否需要断开连接
+func (r *rateLimiter) check(conn Channel, size int) (pass bool, closed bool) {
+	if r == nil {
+		return true, false
+	}
+	if r.bytes != nil && float64(size) > r.bytes.burst {
+		//令牌永远不够，丢弃或等待都无法处理
+		logger.Warnf("rate limit frame larger than burst close:%s,size:%d", conn.Ip(), size)
+		r.notify(conn, size)
+		return false, true
+	}
+	now := r.now()
+	if r.limit.Policy == LimitDelay {
+		wait := r.messages.reserve(now, 1)
+		if w := r.bytes.reserve(now, float64(size)); w > wait {
+			wait = w
+		}
+		if wait > 0 {
+			r.notify(conn, size)
+			r.sleep(wait)
+			if c, ok := conn.(delayChannel); ok {
+				c.extendReadDeadline()
+			}
+		}
+		return true, false
+	}
+	if r.messages.enough(now, 1) && r.bytes.enough(now, float64(size)) {
+		r.messages.take(1)
+		r.bytes.take(float64(size))
+		return true, false
+	}
+	r.notify(conn, size)
+	if r.limit.Policy == LimitClose {
+		logger.Warnf("rate limit close:%s", conn.Ip())
+		return false, true
+	}
+	return false, false
+}
+
+func (r *rateLimiter) notify(conn Channel, size int) {
+	if r.limit.OnLimit != nil {
+		r.limit.OnLimit(conn, r.limit.Policy, size)
+	}
+}
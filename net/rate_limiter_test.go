@@ -0,0 +1,192 @@
+package net
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(10, 5)
+	b.last = now
+	for i := 0; i < 5; i++ {
+		if !b.enough(now, 1) {
+			t.Fatalf("burst %d", i)
+		}
+		b.take(1)
+	}
+	if b.enough(now, 1) {
+		t.Fatal("burst exceeded")
+	}
+	if !b.enough(now.Add(100*time.Millisecond), 1) {
+		t.Fatal("refill failed")
+	}
+	if wait := b.reserve(now.Add(100*time.Millisecond), 3); wait < 150*time.Millisecond || wait > 250*time.Millisecond {
+		t.Fatalf("wait %v", wait)
+	}
+	var nilBucket *tokenBucket
+	if !nilBucket.enough(now, 1000) || nilBucket.reserve(now, 1000) != 0 {
+		t.Fatal("nil bucket should not limit")
+	}
+}
+
+// fakeClock 限速测试用的时钟，sleep只推进时间
+type fakeClock struct {
+	now   time.Time
+	slept []time.Duration
+}
+
+func (c *fakeClock) install(r *rateLimiter) {
+	r.now = func() time.Time { return c.now }
+	r.sleep = func(d time.Duration) {
+		c.slept = append(c.slept, d)
+		c.now = c.now.Add(d)
+	}
+	for _, b := range []*tokenBucket{r.messages, r.bytes} {
+		if b != nil {
+			b.last = c.now
+		}
+	}
+}
+
+// delayTestChannel 记录限速等待后是否延长读超时
+type delayTestChannel struct {
+	*testChannel
+	extended int
+}
+
+func (c *delayTestChannel) extendReadDeadline() {
+	c.extended++
+}
+
+func TestRateLimiterDrop(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	limited := 0
+	r := newRateLimiter(&RateLimit{
+		Messages: 5,
+		Policy:   LimitDrop,
+		OnLimit:  func(conn Channel, policy LimitPolicy, size int) { limited++ },
+	})
+	clock.install(r)
+	conn := newTestChannel()
+	count := 0
+	for i := 0; i < 20; i++ {
+		pass, closed := r.check(conn, 5)
+		if closed {
+			t.Fatal("drop policy closed connection")
+		}
+		if pass {
+			count++
+		}
+	}
+	if count != 5 || limited != 15 {
+		t.Fatalf("pass %d limited %d", count, limited)
+	}
+	clock.now = clock.now.Add(200 * time.Millisecond)
+	if pass, _ := r.check(conn, 5); !pass {
+		t.Fatal("refill failed")
+	}
+	if pass, _ := r.check(conn, 5); pass {
+		t.Fatal("burst exceeded after refill")
+	}
+}
+
+func TestRateLimiterDelay(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	r := newRateLimiter(&RateLimit{Messages: 10, MessagesBurst: 1, Policy: LimitDelay})
+	clock.install(r)
+	conn := &delayTestChannel{testChannel: newTestChannel()}
+	for i := 0; i < 3; i++ {
+		if pass, closed := r.check(conn, 5); !pass || closed {
+			t.Fatalf("delay policy check %d: pass %v closed %v", i, pass, closed)
+		}
+	}
+	if len(clock.slept) != 2 || clock.slept[0] != 100*time.Millisecond || clock.slept[1] != 100*time.Millisecond {
+		t.Fatalf("slept %v", clock.slept)
+	}
+	if conn.extended != 2 {
+		t.Fatalf("extended %d", conn.extended)
+	}
+}
+
+func TestRateLimiterOversize(t *testing.T) {
+	for _, policy := range []LimitPolicy{LimitDrop, LimitDelay, LimitClose} {
+		clock := &fakeClock{now: time.Now()}
+		limited := 0
+		r := newRateLimiter(&RateLimit{
+			Bytes:   100,
+			Policy:  policy,
+			OnLimit: func(conn Channel, policy LimitPolicy, size int) { limited++ },
+		})
+		clock.install(r)
+		conn := newTestChannel()
+		if pass, closed := r.check(conn, 100); !pass || closed {
+			t.Fatalf("policy %d: burst sized frame rejected", policy)
+		}
+		if pass, closed := r.check(conn, 101); pass || !closed {
+			t.Fatalf("policy %d: oversize frame pass %v closed %v", policy, pass, closed)
+		}
+		if limited != 1 || len(clock.slept) != 0 {
+			t.Fatalf("policy %d: limited %d slept %v", policy, limited, clock.slept)
+		}
+	}
+}
+
+func TestRateLimitDrop(t *testing.T) {
+	var mutex sync.Mutex
+	limited := 0
+	l := &testListener{}
+	addr := freeAddr(t)
+	server := NewTCPServer(addr, l, WithRateLimit(RateLimit{
+		Messages: 5,
+		Policy:   LimitDrop,
+		OnLimit: func(conn Channel, policy LimitPolicy, size int) {
+			mutex.Lock()
+			limited++
+			mutex.Unlock()
+		},
+	}))
+	go server.Listen()
+	defer server.Close()
+	conn := dialRetry(t, addr)
+	defer conn.Close()
+
+	for i := 0; i < 20; i++ {
+		_, _ = conn.Write(frame([]byte("flood")))
+	}
+	waitFor(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return l.dataCount()+limited == 20
+	})
+	//精确数量由TestRateLimiterDrop用固定时钟验证，这里只验证突发部分能通过且不断开
+	if l.dataCount() < 5 {
+		t.Fatalf("data %d", l.dataCount())
+	}
+	if l.disconnectedCount() != 0 {
+		t.Fatal("drop policy closed connection")
+	}
+}
+
+func TestRateLimitClose(t *testing.T) {
+	l := &testListener{}
+	addr := freeAddr(t)
+	server := NewTCPServer(addr, l, WithRateLimit(RateLimit{Bytes: 100, Policy: LimitClose}))
+	go server.Listen()
+	defer server.Close()
+	conn := dialRetry(t, addr)
+	defer conn.Close()
+
+	_, _ = conn.Write(frame(make([]byte, 80)))
+	_, _ = conn.Write(frame(make([]byte, 80)))
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	waitFor(t, func() bool { return l.disconnectedCount() == 1 })
+	if l.dataCount() != 1 {
+		t.Fatalf("data %d", l.dataCount())
+	}
+}
@@ -0,0 +1,114 @@
+package net
+
+import (
+	"errors"
+	"github.com/yhhaiua/engine/util"
+	"time"
+)
+
+const (
+	defaultReconnectInitial    = 1 * time.Second
+	defaultReconnectMax        = 30 * time.Second
+	defaultReconnectMultiplier = 2
+	defaultReconnectJitter     = 0.2
+	runInterval                = 5 * time.Second //连接存活时调用SocketRunListener.Run的周期
+)
+
+// Reconnect 客户端断线重连配置，零值字段使用默认值
+type Reconnect struct {
+	Initial        time.Duration                          //首次重连等待，默认1s
+	Max            time.Duration                          //最大等待，默认30s
+	Multiplier     float64                                //每次失败后等待时间倍数，默认2
+	Jitter         float64                                //随机抖动比例（0-1），默认0.2，避免大量客户端同时重连，负数不抖动
+	MaxAttempts    int                                    //连续失败最大次数，超过后放弃，0不限制
+	OnReconnecting func(attempt int, delay time.Duration) //等待重连前回调，attempt从1开始
+	OnReconnected  func(conn Channel)                     //断线后重新连接成功回调
+	OnGiveUp       func(err error)                        //超过最大次数放弃重连回调
+}
+
+// delay 第attempt次重连前的等待时间（指数退避加随机抖动）
+func (r *Reconnect) delay(attempt int) time.Duration {
+	initial, max, multiplier, jitter := r.Initial, r.Max, r.Multiplier, r.Jitter
+	if initial <= 0 {
+		initial = defaultReconnectInitial
+	}
+	if max <= 0 {
+		max = defaultReconnectMax
+	}
+	if multiplier < 1 {
+		multiplier = defaultReconnectMultiplier
+	}
+	if jitter == 0 || jitter > 1 {
+		jitter = defaultReconnectJitter
+	}
+	d := float64(initial)
+	for i := 1; i < attempt && d < float64(max); i++ {
+		d *= multiplier
+	}
+	if d > float64(max) {
+		d = float64(max)
+	}
+	//在[d*(1-jitter), d*(1+jitter)]范围内随机
+	if jitter > 0 {
+		spread := int64(d * jitter)
+		d += float64(util.Int63n(2*spread+1) - spread)
+	}
+	return time.Duration(d)
+}
+
+// giveUp 连续失败failures次后是否放弃
+func (r *Reconnect) giveUp(failures int) bool {
+	return r.MaxAttempts > 0 && failures >= r.MaxAttempts
+}
+
+// errCreateConn 连接成功但创建Channel失败（如解码器创建失败）
+var errCreateConn = errors.New("create conn failed")
+
+// loop 按退避策略循环连接直到chStop关闭或放弃，err为Start中首次连接的错误（计入连续失败次数），reconnect表示之前已连接成功过。
+// name用于日志，connect返回新连接及其断开通知，serve在连接存活期间阻塞，返回true表示已停止
+func (r *Reconnect) loop(name string, chStop chan struct{}, err error, reconnect bool,
+	connect func() (Channel, chan struct{}, error), serve func(conn Channel, chLost chan struct{}) bool) {
+	failures := 0
+	if err != nil {
+		failures = 1
+	}
+	for {
+		if r.giveUp(failures) {
+			logger.Errorf("%s,connect give up after %d attempts:%s", name, failures, err.Error())
+			if r.OnGiveUp != nil {
+				r.OnGiveUp(err)
+			}
+			return
+		}
+		//断开后也按退避等待，避免网络波动后所有客户端同时重连
+		if failures > 0 || reconnect {
+			attempt := max(failures, 1)
+			delay := r.delay(attempt)
+			if r.OnReconnecting != nil {
+				r.OnReconnecting(attempt, delay)
+			}
+			timer := time.NewTimer(delay)
+			select {
+			case <-chStop:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+		var conn Channel
+		var chLost chan struct{}
+		conn, chLost, err = connect()
+		if err != nil {
+			failures++
+			continue
+		}
+		if reconnect && r.OnReconnected != nil {
+			r.OnReconnected(conn)
+		}
+		reconnect = true
+		if serve(conn, chLost) {
+			return
+		}
+		failures = 0
+	}
+}
@@ -0,0 +1,299 @@
+package net
+
+import (
+	"github.com/yhhaiua/engine/buffer"
+	"github.com/yhhaiua/engine/handler"
+	"github.com/yhhaiua/engine/util"
+	"sort"
+	"sync"
+)
+
+// Registry 服务端存活连接管理：按编号和用户查找、遍历、分组、广播和踢人
+type Registry struct {
+	conns   sync.Map
+	count   util.AtomicInteger
+	mutex   sync.RWMutex
+	groups  map[string]map[int64]Channel
+	joined  map[int64]map[string]struct{}
+	users   map[int64]Channel //用户id -> 连接
+	bound   map[int64]int64   //连接编号 -> 用户id
+	encoder handler.Encoder
+}
+
+func newRegistry(encoder handler.Encoder) *Registry {
+	return &Registry{
+		encoder: encoder,
+		groups:  make(map[string]map[int64]Channel),
+		joined:  make(map[int64]map[string]struct{}),
+		users:   make(map[int64]Channel),
+		bound:   make(map[int64]int64),
+	}
+}
+
+// add 连接建立后加入
+func (r *Registry) add(conn Channel) {
+	r.conns.Store(conn.Id(), conn)
+	r.count.IncrementAndGet()
+}
+
+// remove 连接断开后移除，同时退出所有分组并解除用户绑定
+func (r *Registry) remove(conn Channel) {
+	//与Join、Bind的存活检查在同一个锁中，避免断开的连接留在分组中
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.conns.LoadAndDelete(conn.Id()); !ok {
+		return
+	}
+	r.count.DecrementAndGet()
+	for name := range r.joined[conn.Id()] {
+		r.leave(name, conn.Id())
+	}
+	delete(r.joined, conn.Id())
+	r.unbind(conn.Id())
+}
+
+// Get 根据连接编号查找连接
+func (r *Registry) Get(id int64) (Channel, bool) {
+	v, ok := r.conns.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return v.(Channel), true
+}
+
+// Count 在线连接数
+func (r *Registry) Count() int {
+	return r.count.Get()
+}
+
+// Range 遍历所有连接，f返回false时停止
+func (r *Registry) Range(f func(conn Channel) bool) {
+	r.conns.Range(func(_, value any) bool {
+		return f(value.(Channel))
+	})
+}
+
+// Kick 踢掉连接，msg不为空时先发送给目标再关闭
+func (r *Registry) Kick(id int64, msg []byte) bool {
+	conn, ok := r.Get(id)
+	if !ok {
+		return false
+	}
+	if msg != nil {
+		conn.WriteAndFlush(msg)
+	}
+	conn.Close()
+	return true
+}
+
+// Broadcast 向所有连接发送同一份已编码的数据
+func (r *Registry) Broadcast(msg []byte) {
+	r.Range(func(conn Channel) bool {
+		conn.WriteAndFlush(msg)
+		return true
+	})
+}
+
+// BroadcastPacket 使用服务器编码器编码一次后向所有连接发送
+func (r *Registry) BroadcastPacket(cmd buffer.PacketCons) {
+	r.Broadcast(r.encoder.Encode(cmd))
+}
+
+// Join 连接加入分组（公会、房间、场景等），连接断开后自动退出
+func (r *Registry) Join(name string, conn Channel) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.conns.Load(conn.Id()); !ok {
+		return
+	}
+	group, ok := r.groups[name]
+	if !ok {
+		group = make(map[int64]Channel)
+		r.groups[name] = group
+	}
+	group[conn.Id()] = conn
+	names, ok := r.joined[conn.Id()]
+	if !ok {
+		names = make(map[string]struct{})
+		r.joined[conn.Id()] = names
+	}
+	names[name] = struct{}{}
+}
+
+// Leave 连接退出分组
+func (r *Registry) Leave(name string, conn Channel) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.leave(name, conn.Id())
+	if names, ok := r.joined[conn.Id()]; ok {
+		delete(names, name)
+		if len(names) == 0 {
+			delete(r.joined, conn.Id())
+		}
+	}
+}
+
+func (r *Registry) leave(name string, id int64) {
+	group, ok := r.groups[name]
+	if !ok {
+		return
+	}
+	delete(group, id)
+	if len(group) == 0 {
+		delete(r.groups, name)
+	}
+}
+
+// GroupCount 分组内连接数
+func (r *Registry) GroupCount(name string) int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return len(r.groups[name])
+}
+
+// Members 分组内所有连接
+func (r *Registry) Members(name string) []Channel {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	group := r.groups[name]
+	members := make([]Channel, 0, len(group))
+	for _, conn := range group {
+		members = append(members, conn)
+	}
+	return members
+}
+
+// Multicast 向分组内所有连接发送同一份已编码的数据
+func (r *Registry) Multicast(name string, msg []byte) {
+	//先取出成员再发送，发送时不持有锁
+	for _, conn := range r.Members(name) {
+		conn.WriteAndFlush(msg)
+	}
+}
+
+// MulticastPacket 使用服务器编码器编码一次后向分组内所有连接发送
+func (r *Registry) MulticastPacket(name string, cmd buffer.PacketCons) {
+	r.Multicast(name, r.encoder.Encode(cmd))
+}
+
+// Bind 登录成功后将连接绑定到用户，之后可按用户id查找。
+// 同一用户已绑定其他连接时（重复登录），旧连接解除绑定后被踢掉，kickMsg不为空时先发送给旧连接，返回被踢的旧连接。
+// 连接已断开时返回false；旧连接随后的OnDisconnected中UserOf返回false，可据此区分被顶号
+func (r *Registry) Bind(conn Channel, userId int64, kickMsg []byte) (Channel, bool) {
+	r.mutex.Lock()
+	if _, ok := r.conns.Load(conn.Id()); !ok {
+		r.mutex.Unlock()
+		return nil, false
+	}
+	old, ok := r.users[userId]
+	if ok && old.Id() == conn.Id() {
+		r.mutex.Unlock()
+		return nil, true
+	}
+	if ok {
+		delete(r.bound, old.Id())
+	}
+	//连接换绑其他用户
+	r.unbind(conn.Id())
+	r.users[userId] = conn
+	r.bound[conn.Id()] = userId
+	r.mutex.Unlock()
+	if old == nil {
+		return nil, true
+	}
+	logger.Infof("user login again,kick old conn:%d,userId:%d,ip:%s", old.Id(), userId, old.Ip())
+	if kickMsg != nil {
+		old.WriteAndFlush(kickMsg)
+	}
+	old.Close()
+	return old, true
+}
+
+// Unbind 解除连接的用户绑定（如登出），连接断开时自动解除
+func (r *Registry) Unbind(conn Channel) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.unbind(conn.Id())
+}
+
+func (r *Registry) unbind(id int64) {
+	userId, ok := r.bound[id]
+	if !ok {
+		return
+	}
+	delete(r.bound, id)
+	if cur, ok := r.users[userId]; ok && cur.Id() == id {
+		delete(r.users, userId)
+	}
+}
+
+// User 根据用户id查找连接
+func (r *Registry) User(userId int64) (Channel, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	conn, ok := r.users[userId]
+	return conn, ok
+}
+
+// UserOf 连接绑定的用户id
+func (r *Registry) UserOf(conn Channel) (int64, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	userId, ok := r.bound[conn.Id()]
+	return userId, ok
+}
+
+// UserCount 已绑定用户的连接数
+func (r *Registry) UserCount() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return len(r.users)
+}
+
+// KickUser 踢掉用户的连接，msg不为空时先发送给目标再关闭
+func (r *Registry) KickUser(userId int64, msg []byte) bool {
+	conn, ok := r.User(userId)
+	if !ok {
+		return false
+	}
+	return r.Kick(conn.Id(), msg)
+}
+
+// SendUser 向用户的连接发送已编码的数据，用户不在线返回false
+func (r *Registry) SendUser(userId int64, msg []byte) bool {
+	conn, ok := r.User(userId)
+	if !ok {
+		return false
+	}
+	conn.WriteAndFlush(msg)
+	return true
+}
+
+// Stats 所有支持统计的连接的流量
+func (r *Registry) Stats() []ConnStats {
+	stats := make([]ConnStats, 0, r.Count())
+	r.Range(func(conn Channel) bool {
+		if c, ok := conn.(StatsChannel); ok {
+			stats = append(stats, c.Stats())
+		}
+		return true
+	})
+	return stats
+}
+
+// TopConns 按less排序后的前n个连接，less为空时按接收字节数从大到小，用于找出流量最大的客户端
+func (r *Registry) TopConns(n int, less func(a, b ConnStats) bool) []ConnStats {
+	if less == nil {
+		less = func(a, b ConnStats) bool {
+			return a.BytesIn > b.BytesIn
+		}
+	}
+	stats := r.Stats()
+	sort.Slice(stats, func(i, j int) bool {
+		return less(stats[i], stats[j])
+	})
+	if len(stats) > n {
+		stats = stats[:n]
+	}
+	return stats
+}
@@ -0,0 +1,210 @@
+package net
+
+import (
+	"sync"
+	"testing"
+)
+
+type testChannel struct {
+	mutex  sync.Mutex
+	id     int64
+	writes [][]byte
+	closed bool
+	attrs  Attrs
+}
+
+func newTestChannel() *testChannel {
+	return &testChannel{id: globalId.IncrementAndGet()}
+}
+
+func (c *testChannel) WriteAndFlush(msg []byte) {
+	c.mutex.Lock()
+	c.writes = append(c.writes, msg)
+	c.mutex.Unlock()
+}
+
+func (c *testChannel) Close() {
+	c.mutex.Lock()
+	c.closed = true
+	c.mutex.Unlock()
+}
+
+func (c *testChannel) Ip() string {
+	return "127.0.0.1"
+}
+
+func (c *testChannel) Destroy() {}
+
+func (c *testChannel) Id() int64 {
+	return c.id
+}
+
+func (c *testChannel) Attrs() *Attrs {
+	return &c.attrs
+}
+
+func (c *testChannel) writeCount() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.writes)
+}
+
+func TestRegistryGroups(t *testing.T) {
+	r := newRegistry(defaultEncoder)
+	a, b, c := newTestChannel(), newTestChannel(), newTestChannel()
+	r.add(a)
+	r.add(b)
+	r.add(c)
+	if r.Count() != 3 {
+		t.Fatalf("count %d", r.Count())
+	}
+	if conn, ok := r.Get(b.Id()); !ok || conn != b {
+		t.Fatal("get failed")
+	}
+
+	r.Join("room", a)
+	r.Join("room", b)
+	r.Join("guild", b)
+	r.Multicast("room", []byte("room"))
+	if a.writeCount() != 1 || b.writeCount() != 1 || c.writeCount() != 0 {
+		t.Fatal("multicast failed")
+	}
+	r.Broadcast([]byte("all"))
+	if a.writeCount() != 2 || b.writeCount() != 2 || c.writeCount() != 1 {
+		t.Fatal("broadcast failed")
+	}
+
+	r.Leave("room", a)
+	if r.GroupCount("room") != 1 {
+		t.Fatalf("room count %d", r.GroupCount("room"))
+	}
+	r.remove(b)
+	if r.GroupCount("room") != 0 || r.GroupCount("guild") != 0 {
+		t.Fatal("removed connection still in group")
+	}
+	if len(r.groups) != 0 || len(r.joined) != 0 {
+		t.Fatal("empty groups not released")
+	}
+	r.Join("room", b)
+	if r.GroupCount("room") != 0 {
+		t.Fatal("offline connection joined group")
+	}
+
+	if !r.Kick(c.Id(), []byte("bye")) || !c.closed || c.writeCount() != 2 {
+		t.Fatal("kick failed")
+	}
+	if r.Kick(b.Id(), nil) {
+		t.Fatal("kick offline connection")
+	}
+}
+
+func TestRegistryRemoveRace(t *testing.T) {
+	r := newRegistry(defaultEncoder)
+	var wg sync.WaitGroup
+	for i := 0; i < 1000; i++ {
+		conn := newTestChannel()
+		r.add(conn)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			r.Join("room", conn)
+			r.Bind(conn, conn.Id(), nil)
+		}()
+		go func() {
+			defer wg.Done()
+			r.remove(conn)
+		}()
+	}
+	wg.Wait()
+	if r.Count() != 0 || r.GroupCount("room") != 0 || len(r.joined) != 0 || r.UserCount() != 0 || len(r.bound) != 0 {
+		t.Fatalf("count %d room %d joined %d users %d", r.Count(), r.GroupCount("room"), len(r.joined), r.UserCount())
+	}
+}
+
+func TestTCPServerRegistry(t *testing.T) {
+	l := &testListener{}
+	addr := freeAddr(t)
+	server := NewTCPServer(addr, l)
+	go server.Listen()
+	defer server.Close()
+	conn1 := dialRetry(t, addr)
+	defer conn1.Close()
+	conn2 := dialRetry(t, addr)
+	defer conn2.Close()
+	waitFor(t, func() bool { return server.Registry().Count() == 2 })
+
+	server.Registry().Broadcast(frame([]byte("hello")))
+	if string(readFrame(t, conn1)) != "hello" || string(readFrame(t, conn2)) != "hello" {
+		t.Fatal("broadcast failed")
+	}
+	_ = conn1.Close()
+	waitFor(t, func() bool { return server.Registry().Count() == 1 })
+}
+
+func (c *testChannel) isClosed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closed
+}
+
+func TestRegistryBind(t *testing.T) {
+	r := newRegistry(defaultEncoder)
+	a, b := newTestChannel(), newTestChannel()
+	r.add(a)
+	r.add(b)
+	if old, ok := r.Bind(a, 1001, nil); !ok || old != nil {
+		t.Fatal("bind failed")
+	}
+	if conn, ok := r.User(1001); !ok || conn != a {
+		t.Fatal("user lookup failed")
+	}
+
+	//重复登录踢掉旧连接
+	old, ok := r.Bind(b, 1001, []byte("kick"))
+	if !ok || old != a || !a.isClosed() || a.writeCount() != 1 {
+		t.Fatal("old session not kicked")
+	}
+	if _, ok := r.UserOf(a); ok {
+		t.Fatal("old session still bound")
+	}
+	//旧连接断开不影响新绑定
+	r.remove(a)
+	if conn, ok := r.User(1001); !ok || conn != b {
+		t.Fatal("new session unbound")
+	}
+
+	//换绑其他用户
+	r.Bind(b, 1002, nil)
+	if _, ok := r.User(1001); ok || r.UserCount() != 1 {
+		t.Fatal("rebind left old user")
+	}
+	r.remove(b)
+	if _, ok := r.User(1002); ok || r.UserCount() != 0 {
+		t.Fatal("user not unbound on remove")
+	}
+	if _, ok := r.Bind(b, 1003, nil); ok {
+		t.Fatal("bind removed conn")
+	}
+}
+
+func TestChannelAttrs(t *testing.T) {
+	roleId := NewAttrKey[int64]("roleId")
+	locale := NewAttrKey[string]("locale")
+	other := NewAttrKey[int64]("roleId")
+	c := newTestChannel()
+	if _, ok := roleId.Get(c); ok {
+		t.Fatal("unset attr found")
+	}
+	roleId.Set(c, 7)
+	locale.Set(c, "zh")
+	if v, ok := roleId.Get(c); !ok || v != 7 || locale.Value(c) != "zh" {
+		t.Fatal("attr get failed")
+	}
+	if _, ok := other.Get(c); ok {
+		t.Fatal("keys with same name collide")
+	}
+	roleId.Delete(c)
+	if roleId.Value(c) != 0 {
+		t.Fatal("attr not deleted")
+	}
+}
@@ -0,0 +1,171 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"github.com/yhhaiua/engine/buffer"
+	"io"
+	"sync"
+	"time"
+)
+
+// ReplayOptions 回放配置
+type ReplayOptions struct {
+	Timing bool    //按录制时的时间间隔回放，否则尽快回放
+	Speed  float64 //Timing时的回放速度倍数，默认1
+	Ids    []int64 //只回放这些连接，为空回放所有连接
+}
+
+// ReplayConn 回放时的虚拟连接，编号和ip与录制时相同，发送的数据保存在内存中用于和录制时对比
+type ReplayConn struct {
+	id       int64
+	ip       string
+	attrs    Attrs
+	mutex    sync.Mutex
+	recorded [][]byte
+	written  [][]byte
+	closed   bool
+}
+
+func (c *ReplayConn) Id() int64 {
+	return c.id
+}
+
+func (c *ReplayConn) Ip() string {
+	return c.ip
+}
+
+// Attrs 连接属性
+func (c *ReplayConn) Attrs() *Attrs {
+	return &c.attrs
+}
+
+// WriteAndFlush 保存发送的数据
+func (c *ReplayConn) WriteAndFlush(msg []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return
+	}
+	c.written = append(c.written, append([]byte(nil), msg...))
+}
+
+func (c *ReplayConn) Close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed = true
+}
+
+func (c *ReplayConn) Destroy() {}
+
+// Closed 回放中是否调用过Close
+func (c *ReplayConn) Closed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closed
+}
+
+// Written 回放时发送的数据
+func (c *ReplayConn) Written() [][]byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([][]byte(nil), c.written...)
+}
+
+// Recorded 录制时发送的数据
+func (c *ReplayConn) Recorded() [][]byte {
+	return c.recorded
+}
+
+// Replay 将录制的连接和收到的消息按顺序回放到listener（OnConnected、OnData、OnDisconnected），
+// 录制结束时未断开的连接同样通知断开，返回所有回放的连接
+func Replay(ctx context.Context, r io.Reader, listener SocketListener, opts ReplayOptions) (map[int64]*ReplayConn, error) {
+	reader, err := NewCaptureReader(r)
+	if err != nil {
+		return nil, err
+	}
+	speed := opts.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+	var filter map[int64]struct{}
+	if len(opts.Ids) > 0 {
+		filter = make(map[int64]struct{}, len(opts.Ids))
+		for _, id := range opts.Ids {
+			filter[id] = struct{}{}
+		}
+	}
+	conns := make(map[int64]*ReplayConn)
+	open := make(map[int64]*ReplayConn)
+	defer func() {
+		for _, conn := range open {
+			listener.OnDisconnected(conn)
+		}
+	}()
+	start := time.Now()
+	for {
+		record, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return conns, nil
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			logger.Warnf("replay capture truncated")
+			return conns, nil
+		}
+		if err != nil {
+			return conns, err
+		}
+		if filter != nil {
+			if _, ok := filter[record.ConnId]; !ok {
+				continue
+			}
+		}
+		if opts.Timing {
+			at := start.Add(time.Duration(float64(record.Time.Sub(reader.Start())) / speed))
+			if err = sleepUntil(ctx, at); err != nil {
+				return conns, err
+			}
+		} else if err = ctx.Err(); err != nil {
+			return conns, err
+		}
+		conn := open[record.ConnId]
+		switch record.Kind {
+		case CaptureConnect:
+			if conn != nil {
+				continue
+			}
+			conn = &ReplayConn{id: record.ConnId, ip: string(record.Data)}
+			conns[conn.id] = conn
+			open[conn.id] = conn
+			listener.OnConnected(conn)
+		case CaptureInbound:
+			if conn != nil {
+				listener.OnData(conn, buffer.NewBuffer(record.Data))
+			}
+		case CaptureOutbound:
+			if conn != nil {
+				conn.recorded = append(conn.recorded, record.Data)
+			}
+		case CaptureDisconnect:
+			if conn != nil {
+				delete(open, conn.id)
+				listener.OnDisconnected(conn)
+			}
+		}
+	}
+}
+
+func sleepUntil(ctx context.Context, at time.Time) error {
+	d := time.Until(at)
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,321 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/yhhaiua/engine/buffer"
+	"github.com/yhhaiua/engine/util"
+	"sync"
+)
+
+const (
+	DefaultRPCRequestCode  = -10001 //请求消息编号
+	DefaultRPCResponseCode = -10002 //响应消息编号
+
+	rpcStatusOK    = 0
+	rpcStatusError = 1
+)
+
+var (
+	// ErrRPCDisconnected 连接已断开或等待响应时连接断开
+	ErrRPCDisconnected = errors.New("net.RPC: channel disconnected")
+)
+
+// disconnected 连接是否已断开，断开原因在OnDisconnected通知前设置；未提供断开原因的自定义Channel总是返回false
+func disconnected(conn Channel) bool {
+	c, ok := conn.(interface{ DisconnectReason() DisconnectReason })
+	return ok && c.DisconnectReason() != 0
+}
+
+// RPCError 对方处理请求返回的错误
+type RPCError struct {
+	Message string
+}
+
+func (e *RPCError) Error() string {
+	return "net.RPC: remote error: " + e.Message
+}
+
+// RPCHandler 请求处理函数，返回的响应由RPC写回请求方，返回错误时请求方收到RPCError
+type RPCHandler func(session *TcpSession, req buffer.PacketCons) (buffer.PacketCons, error)
+
+// RPCCall 一次调用，完成后Done关闭
+type RPCCall struct {
+	id    int64
+	conn  Channel
+	reply buffer.PacketCons
+	done  chan struct{}
+	err   error
+}
+
+// Done 调用完成（收到响应、出错或连接断开）后关闭
+func (c *RPCCall) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err 调用结果，Done关闭后有效
+func (c *RPCCall) Err() error {
+	return c.err
+}
+
+func (c *RPCCall) finish(err error) {
+	c.err = err
+	close(c.done)
+}
+
+// rpcPacket 请求和响应的外层消息：编号 + 请求id（+ 响应状态）+ 内层消息，出错时内层为错误信息
+type rpcPacket struct {
+	code     int
+	id       int64
+	response bool
+	status   int8
+	body     buffer.PacketCons
+	errMsg   string
+}
+
+func (p *rpcPacket) Write(buf *buffer.ByteBuf) {
+	buf.WriteNInt32(int32(p.code))
+	buf.WriteNInt64(p.id)
+	if p.response {
+		buf.WriteNInt8(p.status)
+		if p.status != rpcStatusOK {
+			buf.WriteNString(p.errMsg)
+			return
+		}
+	}
+	p.body.Write(buf)
+}
+
+func (p *rpcPacket) Read(buf *buffer.ByteBuf) {}
+
+func (p *rpcPacket) Copy() buffer.PacketCons {
+	return &rpcPacket{code: p.code}
+}
+
+func (p *rpcPacket) CodeId() int {
+	return p.code
+}
+
+func (p *rpcPacket) Module() string {
+	return "rpc"
+}
+
+func (p *rpcPacket) GetStage() int {
+	return 0
+}
+
+type rpcEntry struct {
+	prototype buffer.PacketCons
+	handler   RPCHandler
+}
+
+// RPC 基于Channel的请求响应，请求方Call发送带请求id的消息并等待响应，处理方按内层消息编号调用注册的处理函数并写回响应。
+// 在SocketListener的OnData中先调用RPC.OnData，OnDisconnected中调用RPC.OnDisconnected清理等待中的调用
+type RPC struct {
+	mutex        sync.Mutex
+	pending      map[int64]*RPCCall
+	nextId       util.AtomicLong
+	entries      map[int]*rpcEntry
+	codeReader   CodeReader
+	requestCode  int
+	responseCode int
+}
+
+func NewRPC() *RPC {
+	return &RPC{
+		pending:      make(map[int64]*RPCCall),
+		entries:      make(map[int]*rpcEntry),
+		codeReader:   PeekCode,
+		requestCode:  DefaultRPCRequestCode,
+		responseCode: DefaultRPCResponseCode,
+	}
+}
+
+// SetCodes 自定义请求和响应的消息编号，与业务消息编号冲突时使用，两端需一致
+func (r *RPC) SetCodes(requestCode, responseCode int) {
+	r.requestCode = requestCode
+	r.responseCode = responseCode
+}
+
+// SetCodeReader 自定义内层消息编号读取
+func (r *RPC) SetCodeReader(reader CodeReader) {
+	r.codeReader = reader
+}
+
+// Handle 注册请求原型和处理函数，编号取自prototype.CodeId()，需在服务启动前完成
+func (r *RPC) Handle(prototype buffer.PacketCons, handler RPCHandler) {
+	code := prototype.CodeId()
+	if _, ok := r.entries[code]; ok {
+		logger.Warnf("rpc code repeat register:%d", code)
+	}
+	r.entries[code] = &rpcEntry{prototype: prototype, handler: handler}
+}
+
+// Go 发送请求，不等待响应，响应读取到reply中，需使用Wait或Done等待，连接已断开时调用立即完成并返回ErrRPCDisconnected
+func (r *RPC) Go(conn Channel, req buffer.PacketCons, reply buffer.PacketCons) *RPCCall {
+	call := &RPCCall{
+		id:    r.nextId.IncrementAndGet(),
+		conn:  conn,
+		reply: reply,
+		done:  make(chan struct{}),
+	}
+	//与OnDisconnected在同一个锁中，断开后加入的调用不会遗留
+	r.mutex.Lock()
+	if disconnected(conn) {
+		r.mutex.Unlock()
+		call.finish(ErrRPCDisconnected)
+		return call
+	}
+	r.pending[call.id] = call
+	r.mutex.Unlock()
+	packet := &rpcPacket{code: r.requestCode, id: call.id, body: req}
+	conn.WriteAndFlush(channelEncoder(conn).Encode(packet))
+	return call
+}
+
+// Call 发送请求并等待响应，ctx超时或取消时返回ctx.Err()
+func (r *RPC) Call(ctx context.Context, conn Channel, req buffer.PacketCons, reply buffer.PacketCons) error {
+	return r.Wait(ctx, r.Go(conn, req, reply))
+}
+
+// Wait 等待调用完成，ctx超时或取消时放弃调用，之后到达的响应被丢弃
+func (r *RPC) Wait(ctx context.Context, call *RPCCall) error {
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		if r.take(call.id) == nil {
+			//超时的同时已收到响应
+			<-call.done
+			return call.err
+		}
+		call.finish(ctx.Err())
+		return ctx.Err()
+	}
+}
+
+// Pending 等待响应的调用数
+func (r *RPC) Pending() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.pending)
+}
+
+// take 取出等待中的调用，返回nil表示调用已完成或超时
+func (r *RPC) take(id int64) *RPCCall {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	call, ok := r.pending[id]
+	if ok {
+		delete(r.pending, id)
+	}
+	return call
+}
+
+// OnData 处理请求和响应消息，返回false表示不是rpc消息，由调用方继续处理
+func (r *RPC) OnData(conn Channel, msg *buffer.ByteBuf) bool {
+	code, err := PeekCode(msg)
+	if err != nil || (code != r.requestCode && code != r.responseCode) {
+		return false
+	}
+	if msg.ReadableBytes() < 12 {
+		logger.Errorf("rpc msg too short:%d,Ip:%s", msg.ReadableBytes(), conn.Ip())
+		return true
+	}
+	msg.SkipBytes(4)
+	id := msg.ReadNInt64()
+	if code == r.requestCode {
+		r.serve(conn, id, msg)
+	} else {
+		r.reply(conn, id, msg)
+	}
+	return true
+}
+
+// OnDisconnected 连接断开，等待该连接响应的调用返回ErrRPCDisconnected
+func (r *RPC) OnDisconnected(conn Channel) {
+	r.mutex.Lock()
+	var ids []int64
+	for id, call := range r.pending {
+		if call.conn == conn {
+			ids = append(ids, id)
+		}
+	}
+	r.mutex.Unlock()
+	for _, id := range ids {
+		if call := r.take(id); call != nil {
+			call.finish(ErrRPCDisconnected)
+		}
+	}
+}
+
+// serve 调用处理函数并写回响应
+func (r *RPC) serve(conn Channel, id int64, msg *buffer.ByteBuf) {
+	resp := &rpcPacket{code: r.responseCode, id: id, response: true}
+	reply, err := r.handle(conn, msg)
+	if err != nil {
+		resp.status = rpcStatusError
+		resp.errMsg = err.Error()
+	} else if reply == nil {
+		resp.status = rpcStatusError
+		resp.errMsg = "nil reply"
+	} else {
+		resp.body = reply
+	}
+	conn.WriteAndFlush(channelEncoder(conn).Encode(resp))
+}
+
+func (r *RPC) handle(conn Channel, msg *buffer.ByteBuf) (reply buffer.PacketCons, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			logger.TraceErr(e)
+			reply = nil
+			err = fmt.Errorf("handler panic: %v", e)
+		}
+	}()
+	code, err := r.codeReader(msg)
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := r.entries[code]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownCode, code)
+	}
+	req := entry.prototype.Copy()
+	req.Read(msg)
+	return entry.handler(NewTcpSession(conn), req)
+}
+
+// reply 读取响应完成调用，只接受发出请求的连接返回的响应，其他连接伪造的响应被丢弃
+func (r *RPC) reply(conn Channel, id int64, msg *buffer.ByteBuf) {
+	r.mutex.Lock()
+	call, ok := r.pending[id]
+	if ok && call.conn != conn {
+		r.mutex.Unlock()
+		logger.Warnf("rpc reply from other conn:%d,Ip:%s", id, conn.Ip())
+		return
+	}
+	delete(r.pending, id)
+	r.mutex.Unlock()
+	if call == nil {
+		//已超时
+		return
+	}
+	call.finish(readReply(call.reply, msg))
+}
+
+func readReply(reply buffer.PacketCons, msg *buffer.ByteBuf) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("net.RPC: decode reply panic: %v", e)
+		}
+	}()
+	if msg.ReadNInt8() != rpcStatusOK {
+		return &RPCError{Message: msg.ReadNString()}
+	}
+	if reply != nil {
+		reply.Read(msg)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"github.com/yhhaiua/engine/buffer"
+	"testing"
+	"time"
+)
+
+// rpcListener 先交给RPC处理
+type rpcListener struct {
+	testListener
+	rpc *RPC
+}
+
+func (l *rpcListener) OnData(conn Channel, msg *buffer.ByteBuf) {
+	if !l.rpc.OnData(conn, msg) {
+		l.testListener.OnData(conn, msg)
+	}
+}
+
+func (l *rpcListener) OnDisconnected(conn Channel) {
+	l.rpc.OnDisconnected(conn)
+	l.testListener.OnDisconnected(conn)
+}
+
+func TestRPC(t *testing.T) {
+	serverRPC := NewRPC()
+	serverRPC.Handle(&testPacket{code: 1}, func(session *TcpSession, req buffer.PacketCons) (buffer.PacketCons, error) {
+		return &testPacket{code: 2, text: req.(*testPacket).text + "!"}, nil
+	})
+	serverRPC.Handle(&testPacket{code: 3}, func(session *TcpSession, req buffer.PacketCons) (buffer.PacketCons, error) {
+		return nil, errors.New("denied")
+	})
+	serverRPC.Handle(&testPacket{code: 4}, func(session *TcpSession, req buffer.PacketCons) (buffer.PacketCons, error) {
+		time.Sleep(200 * time.Millisecond)
+		return &testPacket{code: 4}, nil
+	})
+	serverRPC.Handle(&testPacket{code: 5}, func(session *TcpSession, req buffer.PacketCons) (buffer.PacketCons, error) {
+		session.Channel().Close()
+		return &testPacket{code: 5}, nil
+	})
+	server := NewPipeServer(&rpcListener{rpc: serverRPC})
+	rpc := NewRPC()
+	l := &rpcListener{rpc: rpc}
+	conn, err := server.Dial(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	//并发调用按请求id对应响应
+	calls := make([]*RPCCall, 10)
+	replies := make([]*testPacket, 10)
+	for i := range calls {
+		replies[i] = &testPacket{}
+		calls[i] = rpc.Go(conn, &testPacket{code: 1, text: string(rune('a' + i))}, replies[i])
+	}
+	for i, call := range calls {
+		if err := rpc.Wait(ctx, call); err != nil {
+			t.Fatal(err)
+		}
+		if replies[i].code != 2 || replies[i].text != string(rune('a'+i))+"!" {
+			t.Fatalf("reply %d: %+v", i, replies[i])
+		}
+	}
+
+	var rpcErr *RPCError
+	if err := rpc.Call(ctx, conn, &testPacket{code: 3}, &testPacket{}); !errors.As(err, &rpcErr) || rpcErr.Message != "denied" {
+		t.Fatalf("err %v", err)
+	}
+	if err := rpc.Call(ctx, conn, &testPacket{code: 9}, &testPacket{}); !errors.As(err, &rpcErr) {
+		t.Fatalf("unknown code err %v", err)
+	}
+
+	timeout, cancelTimeout := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelTimeout()
+	if err := rpc.Call(timeout, conn, &testPacket{code: 4}, &testPacket{}); err != context.DeadlineExceeded {
+		t.Fatalf("timeout err %v", err)
+	}
+	if rpc.Pending() != 0 {
+		t.Fatal("pending call not removed after timeout")
+	}
+
+	//连接断开时等待中的调用返回错误
+	if err := rpc.Call(ctx, conn, &testPacket{code: 5}, &testPacket{}); err != ErrRPCDisconnected {
+		t.Fatalf("disconnect err %v", err)
+	}
+	if rpc.Pending() != 0 || l.dataCount() != 0 {
+		t.Fatal("unexpected pending or data")
+	}
+
+	//已断开的连接立即返回错误，不等待ctx
+	waitFor(t, func() bool { return l.disconnectedCount() == 1 })
+	if err := rpc.Call(context.Background(), conn, &testPacket{code: 1}, &testPacket{}); err != ErrRPCDisconnected {
+		t.Fatalf("closed conn err %v", err)
+	}
+	if rpc.Pending() != 0 {
+		t.Fatal("call on closed conn pending")
+	}
+}
+
+func TestRPCReplyFromOtherConn(t *testing.T) {
+	rpc := NewRPC()
+	a, b := newTestChannel(), newTestChannel()
+	reply := &testPacket{}
+	call := rpc.Go(a, &testPacket{code: 1, text: "a"}, reply)
+	response := func(text string) *buffer.ByteBuf {
+		msg := buffer.NewByteBuf()
+		msg.WriteNInt32(DefaultRPCResponseCode)
+		msg.WriteNInt64(call.id)
+		msg.WriteNInt8(rpcStatusOK)
+		(&testPacket{code: 2, text: text}).Write(msg)
+		return msg
+	}
+
+	//其他连接带着a的请求id返回响应
+	if !rpc.OnData(b, response("spoofed")) {
+		t.Fatal("response not handled")
+	}
+	select {
+	case <-call.Done():
+		t.Fatalf("call completed by other conn: %+v", reply)
+	default:
+	}
+	if rpc.Pending() != 1 {
+		t.Fatalf("pending %d", rpc.Pending())
+	}
+
+	rpc.OnData(a, response("ok"))
+	<-call.Done()
+	if call.Err() != nil || reply.text != "ok" {
+		t.Fatalf("reply %+v err %v", reply, call.Err())
+	}
+}
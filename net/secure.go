@@ -0,0 +1,349 @@
+//加密通道：连接建立后X25519交换密钥，之后每帧使用AES-256-GCM加密，nonce由每个方向的序号生成，
+//篡改、重放或乱序的帧解密失败后关闭连接
+
+package net
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"github.com/gorilla/websocket"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	secureVersion          = 1
+	secureSuiteAES256GCM   = 1
+	secureKeyLength        = 32
+	secureNonceLength      = 12
+	secureTagLength        = 16                                          //AES-GCM认证标签长度
+	secureMaxHello         = 2 + secureKeyLength + ed25519.SignatureSize //握手消息最大长度
+	secureMaxPlain         = 16 << 10                                    //单个加密帧最大明文长度，较大的写入分为多帧
+	secureMaxRecord        = secureMaxPlain + secureTagLength            //单个加密帧最大长度
+	secureReadSize         = 4096
+	defaultSecureHandshake = 5 * time.Second
+	secureInfo             = "engine secure channel"
+)
+
+var (
+	errSecureHandshake = errors.New("secure channel: invalid handshake")
+	errSecureSignature = errors.New("secure channel: server signature verify failed")
+	errSecureRecord    = errors.New("secure channel: record authentication failed")
+	errSecureTooLarge  = errors.New("secure channel: record too large")
+)
+
+// Secure 加密通道配置，tcp和websocket可用，两端需同时开启。
+// 未配置签名密钥时可防窃听和篡改，但无法防中间人，服务器配置PrivateKey且客户端配置ServerKey后校验服务器身份
+type Secure struct {
+	PrivateKey       ed25519.PrivateKey //服务器签名私钥，为空不签名
+	ServerKey        ed25519.PublicKey  //客户端校验的服务器公钥，为空不校验
+	HandshakeTimeout time.Duration      //握手超时，默认5s
+}
+
+func (s *Secure) timeout() time.Duration {
+	if s.HandshakeTimeout > 0 {
+		return s.HandshakeTimeout
+	}
+	return defaultSecureHandshake
+}
+
+// secureCipher 单个方向的加密状态，nonce = iv xor 序号
+type secureCipher struct {
+	aead  cipher.AEAD
+	iv    [secureNonceLength]byte
+	nonce [secureNonceLength]byte
+	seq   uint64
+}
+
+func newSecureCipher(key, iv []byte) (*secureCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	c := &secureCipher{aead: aead}
+	copy(c.iv[:], iv)
+	return c, nil
+}
+
+func (c *secureCipher) next() []byte {
+	c.nonce = c.iv
+	var seq [8]byte
+	binary.BigEndian.PutUint64(seq[:], c.seq)
+	for i, b := range seq {
+		c.nonce[secureNonceLength-8+i] ^= b
+	}
+	c.seq++
+	return c.nonce[:]
+}
+
+// seal 加密后追加到dst
+func (c *secureCipher) seal(dst, plaintext []byte) []byte {
+	return c.aead.Seal(dst, c.next(), plaintext, nil)
+}
+
+// open 解密，失败后序号已不同步，连接必须关闭
+func (c *secureCipher) open(ciphertext []byte) ([]byte, error) {
+	plaintext, err := c.aead.Open(nil, c.next(), ciphertext, nil)
+	if err != nil {
+		return nil, errSecureRecord
+	}
+	return plaintext, nil
+}
+
+// secureChannel 握手后的收发加密状态
+type secureChannel struct {
+	send *secureCipher
+	recv *secureCipher
+}
+
+// secureTransport 握手消息的收发，tcp为长度帧，websocket为二进制消息
+type secureTransport interface {
+	writeRecord(b []byte) error
+	readRecord() ([]byte, error)
+	setDeadline(t time.Time)
+}
+
+type streamTransport struct {
+	conn net.Conn
+}
+
+func (s streamTransport) writeRecord(b []byte) error {
+	out := make([]byte, 4, 4+len(b))
+	binary.BigEndian.PutUint32(out, uint32(len(b)))
+	_, err := s.conn.Write(append(out, b...))
+	return err
+}
+
+func (s streamTransport) readRecord() ([]byte, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(s.conn, head[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(head[:])
+	if n > secureMaxHello {
+		return nil, errSecureTooLarge
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(s.conn, b)
+	return b, err
+}
+
+func (s streamTransport) setDeadline(t time.Time) {
+	_ = s.conn.SetDeadline(t)
+}
+
+type wsTransport struct {
+	conn  *websocket.Conn
+	limit int64 //握手后恢复的单条消息最大长度
+}
+
+func (w wsTransport) writeRecord(b []byte) error {
+	return w.conn.WriteMessage(websocket.BinaryMessage, b)
+}
+
+func (w wsTransport) readRecord() ([]byte, error) {
+	w.conn.SetReadLimit(secureMaxHello)
+	defer w.conn.SetReadLimit(w.limit)
+	_, b, err := w.conn.ReadMessage()
+	return b, err
+}
+
+func (w wsTransport) setDeadline(t time.Time) {
+	_ = w.conn.SetReadDeadline(t)
+	_ = w.conn.SetWriteDeadline(t)
+}
+
+// secureHello 握手消息：版本 + 加密套件 + X25519公钥（+ 服务器签名）
+func secureHello(pub []byte, sig []byte) []byte {
+	b := make([]byte, 0, 2+len(pub)+len(sig))
+	b = append(b, secureVersion, secureSuiteAES256GCM)
+	b = append(b, pub...)
+	return append(b, sig...)
+}
+
+// parseHello 返回对方公钥和签名
+func parseHello(b []byte) ([]byte, []byte, error) {
+	if len(b) < 2+secureKeyLength || b[0] != secureVersion || b[1] != secureSuiteAES256GCM {
+		return nil, nil, errSecureHandshake
+	}
+	return b[2 : 2+secureKeyLength], b[2+secureKeyLength:], nil
+}
+
+// secureTranscript 服务器签名的内容
+func secureTranscript(clientPub, serverPub []byte) []byte {
+	b := make([]byte, 0, len(secureInfo)+2*secureKeyLength)
+	b = append(b, secureInfo...)
+	b = append(b, clientPub...)
+	return append(b, serverPub...)
+}
+
+// secureHandshake 客户端发送公钥，服务器回复公钥和签名，双方由共享密钥派生两个方向的密钥和iv
+func secureHandshake(t secureTransport, config *Secure, server bool) (*secureChannel, error) {
+	t.setDeadline(time.Now().Add(config.timeout()))
+	defer t.setDeadline(time.Time{})
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	pub := key.PublicKey().Bytes()
+	var clientPub, serverPub, sig []byte
+	if server {
+		b, err := t.readRecord()
+		if err != nil {
+			return nil, err
+		}
+		if clientPub, _, err = parseHello(b); err != nil {
+			return nil, err
+		}
+		serverPub = pub
+		if config.PrivateKey != nil {
+			sig = ed25519.Sign(config.PrivateKey, secureTranscript(clientPub, serverPub))
+		}
+		if err = t.writeRecord(secureHello(serverPub, sig)); err != nil {
+			return nil, err
+		}
+	} else {
+		clientPub = pub
+		if err = t.writeRecord(secureHello(clientPub, nil)); err != nil {
+			return nil, err
+		}
+		b, err := t.readRecord()
+		if err != nil {
+			return nil, err
+		}
+		if serverPub, sig, err = parseHello(b); err != nil {
+			return nil, err
+		}
+		if config.ServerKey != nil && (len(sig) != ed25519.SignatureSize ||
+			!ed25519.Verify(config.ServerKey, secureTranscript(clientPub, serverPub), sig)) {
+			return nil, errSecureSignature
+		}
+	}
+	peerPub := serverPub
+	if server {
+		peerPub = clientPub
+	}
+	if bytes.Equal(clientPub, serverPub) {
+		return nil, errSecureHandshake
+	}
+	peer, err := ecdh.X25519().NewPublicKey(peerPub)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := key.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	salt := append(append([]byte(nil), clientPub...), serverPub...)
+	material, err := hkdf.Key(sha256.New, secret, salt, secureInfo, 2*(secureKeyLength+secureNonceLength))
+	if err != nil {
+		return nil, err
+	}
+	//客户端到服务器、服务器到客户端
+	c2sKey, s2cKey := material[:secureKeyLength], material[secureKeyLength:2*secureKeyLength]
+	c2sIv, s2cIv := material[2*secureKeyLength:2*secureKeyLength+secureNonceLength], material[2*secureKeyLength+secureNonceLength:]
+	c2s, err := newSecureCipher(c2sKey, c2sIv)
+	if err != nil {
+		return nil, err
+	}
+	s2c, err := newSecureCipher(s2cKey, s2cIv)
+	if err != nil {
+		return nil, err
+	}
+	if server {
+		return &secureChannel{send: s2c, recv: c2s}, nil
+	}
+	return &secureChannel{send: c2s, recv: s2c}, nil
+}
+
+// secureConn tcp加密连接，每个加密帧为4字节大端长度 + 密文，Write超过secureMaxPlain时分为多帧，Read返回解密后的数据
+type secureConn struct {
+	net.Conn
+	channel    *secureChannel
+	writeMutex sync.Mutex
+	raw        []byte //未组成完整帧的密文
+	plain      []byte //未读取的明文
+}
+
+// newSecureConn 握手成功后返回加密连接，失败时关闭连接
+func newSecureConn(conn net.Conn, config *Secure, server bool) (net.Conn, error) {
+	channel, err := secureHandshake(streamTransport{conn: conn}, config, server)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return &secureConn{Conn: conn, channel: channel}, nil
+}
+
+func (c *secureConn) Read(p []byte) (int, error) {
+	for len(c.plain) == 0 {
+		if err := c.readRecord(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.plain)
+	c.plain = c.plain[n:]
+	return n, nil
+}
+
+// readRecord 读取并解密一个完整帧，读超时不丢弃已读取的部分
+func (c *secureConn) readRecord() error {
+	for {
+		need := 4
+		if len(c.raw) >= 4 {
+			n := binary.BigEndian.Uint32(c.raw)
+			if n > secureMaxRecord {
+				return errSecureTooLarge
+			}
+			need += int(n)
+			if len(c.raw) >= need {
+				plain, err := c.channel.recv.open(c.raw[4:need])
+				c.raw = c.raw[need:]
+				c.plain = plain
+				return err
+			}
+		}
+		if cap(c.raw)-len(c.raw) < secureReadSize {
+			raw := make([]byte, len(c.raw), max(need, len(c.raw)+secureReadSize))
+			copy(raw, c.raw)
+			c.raw = raw
+		}
+		m, err := c.Conn.Read(c.raw[len(c.raw):cap(c.raw)])
+		c.raw = c.raw[:len(c.raw)+m]
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *secureConn) Write(p []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	records := (len(p) + secureMaxPlain - 1) / secureMaxPlain
+	out := make([]byte, 0, len(p)+records*(4+secureTagLength))
+	for rest := p; len(rest) > 0; {
+		n := min(len(rest), secureMaxPlain)
+		start := len(out)
+		out = c.channel.send.seal(append(out, 0, 0, 0, 0), rest[:n])
+		binary.BigEndian.PutUint32(out[start:], uint32(len(out)-start-4))
+		rest = rest[n:]
+	}
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,183 @@
+package net
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/yhhaiua/engine/buffer"
+	"net"
+	"testing"
+	"time"
+)
+
+func newSecureKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub, priv
+}
+
+func TestTCPSecure(t *testing.T) {
+	pub, priv := newSecureKey(t)
+	l := &testListener{}
+	l.onData = func(conn Channel, msg *buffer.ByteBuf) {
+		conn.WriteAndFlush(frame(msg.Bytes()))
+	}
+	addr := freeAddr(t)
+	server := NewTCPServer(addr, l, WithSecure(Secure{PrivateKey: priv}))
+	go server.Listen()
+	defer server.Close()
+	dialRetry(t, addr).Close()
+
+	cl := &runListener{}
+	client := NewTCPClient("secure", addr, cl, WithSecure(Secure{ServerKey: pub}))
+	client.Connect()
+	if client.TcpConn() == nil {
+		t.Fatal("connect failed")
+	}
+	defer client.Stop()
+	big := bytes.Repeat([]byte("x"), 100000)
+	client.TcpConn().WriteAndFlush(frame([]byte("hello")))
+	client.TcpConn().WriteAndFlush(frame(big))
+	waitFor(t, func() bool { return cl.dataCount() == 2 })
+	if string(cl.data[0]) != "hello" || !bytes.Equal(cl.data[1], big) {
+		t.Fatalf("got %q", cl.data[0])
+	}
+
+	//服务器公钥不一致时握手失败
+	other, _ := newSecureKey(t)
+	conn, err := newSecureConn(dialRetry(t, addr), &Secure{ServerKey: other}, false)
+	if !errors.Is(err, errSecureSignature) || conn != nil {
+		t.Fatalf("err %v", err)
+	}
+}
+
+func TestTCPSecureTampered(t *testing.T) {
+	l := &testListener{}
+	addr := freeAddr(t)
+	server := NewTCPServer(addr, l, WithSecure(Secure{}))
+	go server.Listen()
+	defer server.Close()
+
+	raw := dialRetry(t, addr)
+	defer raw.Close()
+	conn, err := newSecureConn(raw, &Secure{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Write(frame([]byte("ok"))); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return l.dataCount() == 1 })
+
+	//修改密文中的一个字节
+	sc := conn.(*secureConn)
+	record := sc.channel.send.seal(make([]byte, 4), frame([]byte("evil")))
+	binary.BigEndian.PutUint32(record, uint32(len(record)-4))
+	record[len(record)-1] ^= 1
+	if _, err = raw.Write(record); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return l.disconnectedCount() == 1 })
+	if l.dataCount() != 1 {
+		t.Fatal("tampered frame dispatched")
+	}
+}
+
+func TestTCPSecureLimits(t *testing.T) {
+	l := &testListener{}
+	addr := freeAddr(t)
+	server := NewTCPServer(addr, l, WithSecure(Secure{}), WithConnLimit(1, 0, nil))
+	go server.Listen()
+	defer server.Close()
+
+	//握手中的连接占用名额，超过连接数时不等待握手直接断开
+	pending := dialRetry(t, addr)
+	defer pending.Close()
+	time.Sleep(50 * time.Millisecond)
+	rejected := dialRetry(t, addr)
+	defer rejected.Close()
+	_ = rejected.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := rejected.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+		t.Fatalf("rejected conn err %v", err)
+	}
+
+	//握手消息超过最大长度时断开，不分配内存
+	var head [4]byte
+	binary.BigEndian.PutUint32(head[:], secureMaxRecord)
+	_, _ = pending.Write(head[:])
+	_ = pending.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := pending.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+		t.Fatalf("oversize hello err %v", err)
+	}
+
+	//名额释放后可以连接
+	var conn net.Conn
+	waitFor(t, func() bool {
+		var err error
+		conn, err = newSecureConn(dialRetry(t, addr), &Secure{}, false)
+		return err == nil
+	})
+	defer conn.Close()
+	waitFor(t, func() bool { return l.connectedCount() == 1 })
+
+	//数据帧超过最大长度时断开
+	binary.BigEndian.PutUint32(head[:], secureMaxRecord+1)
+	_, _ = conn.(*secureConn).Conn.Write(head[:])
+	waitFor(t, func() bool { return l.disconnectedCount() == 1 })
+}
+
+func TestWSSecure(t *testing.T) {
+	pub, priv := newSecureKey(t)
+	l := &testListener{}
+	l.onData = func(conn Channel, msg *buffer.ByteBuf) {
+		conn.WriteAndFlush(append([]byte(nil), msg.Bytes()...))
+	}
+	addr := freeAddr(t)
+	server := NewWSServer(addr, l, WithSecure(Secure{PrivateKey: priv}))
+	go server.Listen()
+	defer server.Close()
+	dialRetry(t, addr).Close()
+
+	cl := &testListener{}
+	client := NewWsClient("ws://"+addr+"/", cl, WithSecure(Secure{ServerKey: pub}))
+	if err := client.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop()
+	client.WsConn().WriteAndFlush([]byte("hello"))
+	waitFor(t, func() bool { return cl.dataCount() == 1 })
+	if string(cl.data[0]) != "hello" {
+		t.Fatalf("got %q", cl.data[0])
+	}
+
+	//重放上一帧，序号不一致解密失败
+	c, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	channel, err := secureHandshake(wsTransport{conn: c}, &Secure{ServerKey: pub}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := channel.send.seal(nil, []byte("once"))
+	_ = c.WriteMessage(websocket.BinaryMessage, record)
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, reply, err := c.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := channel.recv.open(reply); err != nil || string(b) != "once" {
+		t.Fatalf("got %q err %v", b, err)
+	}
+	_ = c.WriteMessage(websocket.BinaryMessage, record)
+	waitFor(t, func() bool { return l.disconnectedCount() == 1 })
+	if l.dataCount() != 2 {
+		t.Fatalf("data %d", l.dataCount())
+	}
+}
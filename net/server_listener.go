@@ -0,0 +1,157 @@
+package net
+
+import (
+	"context"
+	"github.com/yhhaiua/engine/buffer"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// shutdownPollInterval 优雅关闭时检查连接是否全部断开的间隔
+const shutdownPollInterval = 50 * time.Millisecond
+
+// SocketShutdownListener 服务器优雅关闭时，在连接关闭前通知（可在此发送"服务器关闭"消息）
+type SocketShutdownListener interface {
+	SocketListener
+	OnShutdown(conn Channel)
+}
+
+// connCloser 可直接断开底层连接
+type connCloser interface {
+	close()
+}
+
+// reasonCloser 记录断开原因后断开底层连接
+type reasonCloser interface {
+	closeWith(reason DisconnectReason)
+}
+
+// RejectReason 拒绝连接的原因
+type RejectReason int
+
+const (
+	RejectMaxConns      RejectReason = iota + 1 //超过最大连接数
+	RejectMaxConnsPerIP                         //超过单个ip最大连接数
+)
+
+// serverListener 服务端监听包装，记录存活的连接
+type serverListener struct {
+	listener SocketListener
+	registry *Registry
+	opts     *Options
+	mutex    sync.Mutex
+	closing  bool
+	total    int
+	perIP    map[string]int
+}
+
+func newServerListener(listener SocketListener, opts *Options) *serverListener {
+	return &serverListener{
+		listener: listener,
+		registry: newRegistry(opts.encoder()),
+		opts:     opts,
+		perIP:    make(map[string]int),
+	}
+}
+
+// admit 检查连接数限制，通过后占用名额，连接断开时释放
+func (s *serverListener) admit(ip string) (RejectReason, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.opts.MaxConns > 0 && s.total >= s.opts.MaxConns {
+		return RejectMaxConns, false
+	}
+	if s.opts.MaxConnsPerIP > 0 && limitIP(ip) && s.perIP[ip] >= s.opts.MaxConnsPerIP {
+		return RejectMaxConnsPerIP, false
+	}
+	s.total++
+	s.perIP[ip]++
+	return 0, true
+}
+
+// limitIP 是否限制该ip的连接数，unix socket和内存管道的Ip为网络类型，所有连接相同，不限制
+func limitIP(ip string) bool {
+	_, err := netip.ParseAddr(ip)
+	return err == nil
+}
+
+func (s *serverListener) release(ip string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.total--
+	if s.perIP[ip] <= 1 {
+		delete(s.perIP, ip)
+	} else {
+		s.perIP[ip]--
+	}
+}
+
+func (s *serverListener) OnConnected(conn Channel) {
+	s.mutex.Lock()
+	closing := s.closing
+	s.registry.add(conn)
+	s.mutex.Unlock()
+	s.listener.OnConnected(conn)
+	if closing {
+		s.notifyShutdown(conn)
+	}
+}
+
+func (s *serverListener) OnDisconnected(conn Channel) {
+	defer func() {
+		s.registry.remove(conn)
+		s.release(conn.Ip())
+	}()
+	s.listener.OnDisconnected(conn)
+}
+
+func (s *serverListener) OnData(conn Channel, msg *buffer.ByteBuf) {
+	s.listener.OnData(conn, msg)
+}
+
+func (s *serverListener) OnIdle(conn Channel, state IdleState) {
+	if l, ok := s.listener.(SocketIdleListener); ok {
+		l.OnIdle(conn, state)
+		return
+	}
+	defaultIdle(conn, state)
+}
+
+// notifyShutdown 通知连接即将关闭，并在发送完队列中的数据后关闭
+func (s *serverListener) notifyShutdown(conn Channel) {
+	if l, ok := s.listener.(SocketShutdownListener); ok {
+		l.OnShutdown(conn)
+	}
+	conn.Close()
+}
+
+// shutdown 通知所有连接关闭，等待连接全部断开，ctx超时后强制断开剩余连接
+func (s *serverListener) shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	s.closing = true
+	s.mutex.Unlock()
+	s.registry.Range(func(conn Channel) bool {
+		//写入队列已满时Close会等待，避免阻塞其他连接
+		go s.notifyShutdown(conn)
+		return true
+	})
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.registry.Count() == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			s.registry.Range(func(conn Channel) bool {
+				if c, ok := conn.(connCloser); ok {
+					c.close()
+				}
+				return true
+			})
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
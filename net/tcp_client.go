@@ -1,17 +1,15 @@
 package net
 
 import (
+	"context"
+	"crypto/tls"
 	"github.com/yhhaiua/engine/buffer"
 	"net"
 	"sync"
 	"time"
 )
 
-var (
-	mTCPConnMap *sync.Map
-	timer       *time.Ticker
-	closeKey    chan string
-)
+const defaultDialTimeout = 10 * time.Second
 
 type TCPClient struct {
 	index    string
@@ -19,55 +17,157 @@ type TCPClient struct {
 	listener SocketRunListener
 	tcpConn  *TCPConn
 	length   int
+	opts     *Options
+	mutex    sync.Mutex
+	chStop   chan struct{}
+	chLost   chan struct{}
+	cancel   context.CancelFunc //Stop时取消正在进行的连接
+	stopped  bool
 }
 
-func NewTCPClient(index string, addr string, listener SocketRunListener) *TCPClient {
+func NewTCPClient(index string, addr string, listener SocketRunListener, opts ...Option) *TCPClient {
 	client := &TCPClient{
 		addr:     addr,
 		listener: listener,
 		index:    index,
 		length:   327670,
+		opts:     newOptions(opts...),
 	}
 	return client
 }
-func NewTCPClientLength(index string, addr string, listener SocketRunListener, length int) *TCPClient {
+func NewTCPClientLength(index string, addr string, listener SocketRunListener, length int, opts ...Option) *TCPClient {
 	client := &TCPClient{
 		addr:     addr,
 		listener: listener,
 		index:    index,
 		length:   length,
+		opts:     newOptions(opts...),
 	}
 	return client
 }
 
-func (client *TCPClient) Start() {
-	client.Connect()
-	mTCPConnMap.Store(client.index, client)
+// Start 同步连接一次并返回结果，之后在后台定时调用Run，断线或首次连接失败后按退避策略自动重连，直到Stop。
+// 连接最长等待DialTimeout，Stop后客户端不能再次Start，需重新创建
+func (client *TCPClient) Start() error {
+	client.mutex.Lock()
+	if client.chStop != nil || client.stopped {
+		client.mutex.Unlock()
+		return nil
+	}
+	chStop := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	client.chStop = chStop
+	client.cancel = cancel
+	client.mutex.Unlock()
+
+	conn, chLost, err := client.connect(ctx)
+	go func() {
+		if err == nil {
+			if client.serve(conn.(*TCPConn), chLost, chStop) {
+				return
+			}
+		}
+		client.opts.Reconnect.loop(client.index, chStop, err, err == nil, func() (Channel, chan struct{}, error) {
+			return client.connect(ctx)
+		}, func(conn Channel, chLost chan struct{}) bool {
+			return client.serve(conn.(*TCPConn), chLost, chStop)
+		})
+	}()
+	return err
 }
+
+// Connect 立即连接一次（不重连），已连接时调用SocketRunListener.Run
 func (client *TCPClient) Connect() {
-	if client.tcpConn != nil {
-		client.listener.Run(client.tcpConn)
+	if conn := client.TcpConn(); conn != nil {
+		client.listener.Run(conn)
 		return
 	}
-	conn := client.dial()
-	if conn != nil {
-		logger.Infof("%s,tcp connect success:%s", client.index, client.addr)
-		client.tcpConn = newTcpConn(conn, client, client.length)
-		if client.tcpConn != nil {
-			client.tcpConn.start()
+	conn, err := client.dial(context.Background())
+	if err == nil {
+		client.connected(conn)
+	}
+}
+
+// TcpConn 当前连接，未连接时为nil
+func (client *TCPClient) TcpConn() *TCPConn {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.tcpConn
+}
+
+// connect 连接一次，返回连接及断开通知
+func (client *TCPClient) connect(ctx context.Context) (Channel, chan struct{}, error) {
+	conn, err := client.dial(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	tcpConn, chLost := client.connected(conn)
+	if tcpConn == nil {
+		return nil, nil, errCreateConn
+	}
+	return tcpConn, chLost, nil
+}
+
+// serve 连接存活期间定时调用Run，返回true表示已停止
+func (client *TCPClient) serve(tcpConn *TCPConn, chLost, chStop chan struct{}) bool {
+	ticker := time.NewTicker(runInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-chStop:
+			tcpConn.Close()
+			return true
+		case <-chLost:
+			return false
+		case <-ticker.C:
+			client.safeRun(tcpConn)
 		}
 	}
+}
 
+func (client *TCPClient) safeRun(tcpConn *TCPConn) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.TraceErr(r)
+		}
+	}()
+	client.listener.Run(tcpConn)
 }
 
-func (client *TCPClient) dial() net.Conn {
+// connected 连接成功后创建TCPConn，返回断开通知
+func (client *TCPClient) connected(conn net.Conn) (*TCPConn, chan struct{}) {
+	logger.Infof("%s,tcp connect success:%s", client.index, client.addr)
+	tcpConn := newTcpConn(conn, client, client.length, client.opts)
+	if tcpConn == nil {
+		_ = conn.Close()
+		return nil, nil
+	}
+	chLost := make(chan struct{})
+	client.mutex.Lock()
+	client.tcpConn = tcpConn
+	client.chLost = chLost
+	client.mutex.Unlock()
+	tcpConn.start()
+	return tcpConn, chLost
+}
 
-	conn, err := net.Dial("tcp", client.addr)
-	if err == nil {
-		return conn
+// dial 建立连接，包括TLS握手在内最长等待DialTimeout（加密握手使用Secure.HandshakeTimeout），ctx取消时立即返回
+func (client *TCPClient) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: client.opts.DialTimeout}
+	var conn net.Conn
+	var err error
+	if client.opts.TLSConfig != nil {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: client.opts.TLSConfig}).DialContext(ctx, client.opts.Network, client.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, client.opts.Network, client.addr)
 	}
-	logger.Errorf("%s,tcp connect err,wait again: %s", client.index, err.Error())
-	return nil
+	if err == nil && client.opts.Secure != nil {
+		conn, err = newSecureConn(conn, client.opts.Secure, false)
+	}
+	if err != nil {
+		logger.Errorf("%s,tcp connect err,wait again: %s", client.index, err.Error())
+	}
+	return conn, err
 }
 
 func (client *TCPClient) OnConnected(conn Channel) {
@@ -77,59 +177,65 @@ func (client *TCPClient) OnConnected(conn Channel) {
 func (client *TCPClient) OnDisconnected(conn Channel) {
 
 	client.listener.OnDisconnected(conn)
-	client.tcpConn = nil
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if client.tcpConn == conn {
+		client.tcpConn = nil
+		close(client.chLost)
+		client.chLost = nil
+	}
 }
 func (client *TCPClient) OnData(conn Channel, msg *buffer.ByteBuf) {
 
 	client.listener.OnData(conn, msg)
 }
 
-func (client *TCPClient) Close() {
-	closeKey <- client.index
+func (client *TCPClient) OnIdle(conn Channel, state IdleState) {
+	if l, ok := client.listener.(SocketIdleListener); ok {
+		l.OnIdle(conn, state)
+		return
+	}
+	defaultIdle(conn, state)
 }
 
-func run() {
-	defer func() {
-		if r := recover(); r != nil {
-			logger.TraceErr(r)
-			go run() //出现退出异常，再次启动
-		}
-	}()
-	for {
-		select {
-		//数据处理
-		case <-timer.C:
-			tcpConnectRun()
-		case key, ok := <-closeKey:
-			if ok {
-				tcpConnectClose(key)
-			}
-		}
+// Stop 停止重连并关闭当前连接
+func (client *TCPClient) Stop() {
+	client.mutex.Lock()
+	if client.stopped {
+		client.mutex.Unlock()
+		return
+	}
+	client.stopped = true
+	chStop := client.chStop
+	tcpConn := client.tcpConn
+	cancel := client.cancel
+	client.mutex.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	if chStop != nil {
+		close(chStop)
+	} else if tcpConn != nil {
+		tcpConn.Close()
 	}
+}
 
+// Close 同Stop，之后客户端不能再次Start
+func (client *TCPClient) Close() {
+	client.Stop()
 }
-func tcpConnectRun() {
-	mTCPConnMap.Range(func(key, value interface{}) bool {
-		connect, ok := value.(*TCPClient)
-		if ok {
-			connect.Connect()
-		}
-		return ok
-	})
-}
-func tcpConnectClose(key string) {
-	v, ok := mTCPConnMap.Load(key)
-	if ok {
-		connect := v.(*TCPClient)
-		if connect.tcpConn != nil {
-			connect.tcpConn.Close()
-		}
-		mTCPConnMap.Delete(key)
-	}
+
+// WriteStats 所有连接的批量写入统计
+func (client *TCPClient) WriteStats() WriteStats {
+	return client.opts.writeStats.snapshot()
 }
-func init() {
-	mTCPConnMap = new(sync.Map)
-	timer = time.NewTicker(5 * time.Second)
-	closeKey = make(chan string)
-	go run()
+
+// BackpressureStats 所有连接写入队列已满的统计
+func (client *TCPClient) BackpressureStats() BackpressureStats {
+	return client.opts.backpressure.snapshot()
+}
+
+// TrafficStats 客户端所有连接（包括重连前的连接）的流量汇总
+func (client *TCPClient) TrafficStats() TrafficStats {
+	return client.opts.traffic.snapshot()
 }
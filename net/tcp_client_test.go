@@ -0,0 +1,162 @@
+package net
+
+import (
+	"crypto/tls"
+	"github.com/yhhaiua/engine/buffer"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReconnectDelay(t *testing.T) {
+	r := &Reconnect{Initial: 100 * time.Millisecond, Max: time.Second, Jitter: -1}
+	want := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, w := range want {
+		if d := r.delay(i + 1); d != w*time.Millisecond {
+			t.Fatalf("attempt %d delay %v", i+1, d)
+		}
+	}
+	r.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := r.delay(1); d < 50*time.Millisecond || d > 150*time.Millisecond {
+			t.Fatalf("jitter delay %v", d)
+		}
+	}
+}
+
+func TestTCPClientReconnect(t *testing.T) {
+	l := &testListener{}
+	addr := freeAddr(t)
+	server := NewTCPServer(addr, l)
+	go server.Listen()
+	defer server.Close()
+	dialRetry(t, addr).Close()
+	waitFor(t, func() bool { return l.disconnectedCount() == 1 })
+
+	var mutex sync.Mutex
+	reconnecting, reconnected := 0, 0
+	cl := &runListener{}
+	client := NewTCPClient("reconnect", addr, cl, WithReconnect(Reconnect{
+		Initial: 20 * time.Millisecond,
+		Jitter:  -1,
+		OnReconnecting: func(attempt int, delay time.Duration) {
+			mutex.Lock()
+			reconnecting++
+			mutex.Unlock()
+		},
+		OnReconnected: func(conn Channel) {
+			mutex.Lock()
+			reconnected++
+			mutex.Unlock()
+		},
+	}))
+	if err := client.Start(); err != nil || client.TcpConn() == nil {
+		t.Fatalf("start connected synchronously: %v", err)
+	}
+	waitFor(t, func() bool { return server.Registry().Count() == 1 })
+
+	//服务器踢掉连接后自动重连
+	server.Registry().Range(func(conn Channel) bool {
+		server.Registry().Kick(conn.Id(), nil)
+		return true
+	})
+	waitFor(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return reconnected == 1
+	})
+	if reconnecting != 1 || cl.connectedCount() != 2 || cl.disconnectedCount() != 1 {
+		t.Fatalf("reconnecting %d connected %d", reconnecting, cl.connectedCount())
+	}
+
+	//停止后不再重连
+	client.Stop()
+	waitFor(t, func() bool { return cl.disconnectedCount() == 2 })
+	time.Sleep(100 * time.Millisecond)
+	if cl.connectedCount() != 2 || server.Registry().Count() != 0 {
+		t.Fatal("reconnected after stop")
+	}
+}
+
+func TestTCPClientGiveUp(t *testing.T) {
+	giveUp := make(chan error, 1)
+	client := NewTCPClient("give-up", freeAddr(t), &runListener{}, WithReconnect(Reconnect{
+		Initial:     time.Millisecond,
+		MaxAttempts: 3,
+		OnGiveUp: func(err error) {
+			giveUp <- err
+		},
+	}))
+	if err := client.Start(); err == nil {
+		t.Fatal("start connected to closed port")
+	}
+	select {
+	case err := <-giveUp:
+		if err == nil {
+			t.Fatal("give up without error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("client did not give up")
+	}
+	client.Stop()
+}
+
+func TestTCPClientDialTimeout(t *testing.T) {
+	//只接受连接不回复，TLS握手一直等待
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	client := NewTCPClient("dial-timeout", ln.Addr().String(), &runListener{},
+		WithTLS(&tls.Config{ServerName: "localhost"}), WithDialTimeout(100*time.Millisecond),
+		WithReconnect(Reconnect{Initial: time.Millisecond, Jitter: -1}))
+	start := time.Now()
+	if err := client.Start(); err == nil {
+		t.Fatal("handshake without server reply")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("dial took %v", elapsed)
+	}
+	//后台重连中也能立即停止
+	time.Sleep(50 * time.Millisecond)
+	client.Stop()
+	if client.Start() != nil || client.TcpConn() != nil {
+		t.Fatal("restarted after stop")
+	}
+}
+
+func TestTCPUnixSocket(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "engine.sock")
+	l := &testListener{}
+	l.onData = func(conn Channel, msg *buffer.ByteBuf) {
+		conn.WriteAndFlush(frame(msg.Bytes()))
+	}
+	server := NewTCPServer(addr, l, WithNetwork("unix"))
+	go server.Listen()
+	defer server.Close()
+
+	cl := &runListener{}
+	client := NewTCPClient("unix", addr, cl, WithNetwork("unix"))
+	waitFor(t, func() bool {
+		client.Connect()
+		return client.TcpConn() != nil
+	})
+	defer client.Stop()
+	client.TcpConn().WriteAndFlush(frame([]byte("local")))
+	waitFor(t, func() bool { return cl.dataCount() == 1 })
+	if string(cl.data[0]) != "local" || l.connected[0].Ip() != "unix" {
+		t.Fatalf("got %q ip %s", cl.data[0], l.connected[0].Ip())
+	}
+}
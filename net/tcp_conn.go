@@ -6,8 +6,8 @@ import (
 	"github.com/yhhaiua/engine/util"
 	"io"
 	"net"
-	"strings"
 	"sync"
+	"time"
 )
 
 const TcpDataLength = 100
@@ -18,12 +18,22 @@ type TCPConn struct {
 	receive       *buffer.ByteBuf
 	listener      SocketListener
 	hd            handler.Handler
-	chData        chan []byte
-	connected     bool
+	encoder       handler.Encoder
+	queue         *writeQueue
 	connectAtomic util.AtomicInteger
-	closeData     bool
+	stopWrite     bool
 	id            int64
 	chStopWrite   chan struct{}
+	opts          *Options
+	mailbox       *mailbox
+	limiter       *rateLimiter
+	writev        bool
+	batch         [][]byte
+	coalesce      []byte
+	writeStats    writeCounter
+	proxyAddr     net.Addr //PROXY protocol头中的原始客户端地址
+	attrs         Attrs
+	metrics       connMetrics
 }
 
 func (t *TCPConn) Id() int64 {
@@ -31,31 +41,77 @@ func (t *TCPConn) Id() int64 {
 }
 
 func (t *TCPConn) Ip() string {
-	str := t.conn.RemoteAddr().String()
-	info := strings.Split(str, ":")
-	return info[0]
+	return addrIp(t.RemoteAddr())
 }
 
-func newTcpConn(conn net.Conn, listener SocketListener, length int) *TCPConn {
+// addrIp 地址中的ip
+func addrIp(addr net.Addr) string {
+	if addr.Network() != "tcp" {
+		//unix socket、内存管道没有ip
+		return addr.Network()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// RemoteAddr 对方地址，开启PROXY protocol时为原始客户端地址
+func (t *TCPConn) RemoteAddr() net.Addr {
+	if t.proxyAddr != nil {
+		return t.proxyAddr
+	}
+	return t.conn.RemoteAddr()
+}
+
+// Attrs 连接属性
+func (t *TCPConn) Attrs() *Attrs {
+	return &t.attrs
+}
+
+// Encoder 连接使用的消息编码器
+func (t *TCPConn) Encoder() handler.Encoder {
+	return t.encoder
+}
+
+func newTcpConn(conn net.Conn, listener SocketListener, length int, opts *Options) *TCPConn {
 	t := new(TCPConn)
 	t.conn = conn
+	t.opts = opts
 	t.listener = listener
 	t.receive = buffer.NewByteBuf()
-	t.chData = make(chan []byte, TcpDataLength)
+	t.queue = newWriteQueue(TcpDataLength, opts, &t.metrics)
 	t.chStopWrite = make(chan struct{})
-	t.connected = true
-	hd, err := handler.NewLengthDecoderClient(length)
+	var hd handler.Handler
+	var err error
+	if opts.NewHandler != nil {
+		hd, err = opts.NewHandler()
+	} else {
+		hd, err = handler.NewLengthDecoderClient(length)
+	}
 	if err != nil {
 		logger.Errorf("new TcpConn err: %s", err.Error())
 		return nil
 	}
 	t.hd = hd
+	t.encoder = opts.encoder()
+	t.limiter = newRateLimiter(opts.RateLimit)
+	switch conn.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		t.writev = true
+	}
+	if opts.Serial {
+		t.mailbox = newMailbox(opts.MailboxLimit)
+	}
 	t.id = globalId.IncrementAndGet()
+	t.metrics.init(opts)
 	return t
 }
 
 func (t *TCPConn) start() {
-
+	t.metrics.start()
+	t.opts.Capture.record(t, CaptureConnect, nil)
 	t.listener.OnConnected(t)
 	go t.read()
 	go t.run()
@@ -67,43 +123,85 @@ func (t *TCPConn) read() {
 		if r := recover(); r != nil {
 			logger.TraceErr(r)
 			t.close()
-			t.listener.OnDisconnected(t)
+			dispatchDisconnected(t.mailbox, t.listener, t)
 		}
 	}()
 	for {
+		if t.opts.ReadIdle > 0 {
+			_ = t.conn.SetReadDeadline(time.Now().Add(t.opts.ReadIdle))
+		}
+		n := t.receive.ReadableBytes()
 		err := t.receive.ReadFrom(t.conn)
+		t.metrics.read(t.receive.ReadableBytes() - n)
+		if ne, ok := err.(net.Error); ok && ne.Timeout() && t.connectAtomic.Get() == 0 {
+			t.idle(ReaderIdle)
+			continue
+		}
+		if err != nil && t.connectAtomic.Get() != 0 {
+			//本端已断开（空闲断开、Close等），原因已记录
+			dispatchDisconnected(t.mailbox, t.listener, t)
+			return
+		}
 		if err == io.EOF {
 			logger.Infof("远程连接：%s,关闭", t.conn.RemoteAddr().String())
+			t.metrics.disconnect(DisconnectRemote)
 			t.close()
-			t.listener.OnDisconnected(t)
+			dispatchDisconnected(t.mailbox, t.listener, t)
 			return
 		}
 		if err != nil {
 			logger.Errorf("read err: %s", err.Error())
+			t.metrics.disconnect(DisconnectReadError)
 			t.close()
-			t.listener.OnDisconnected(t)
+			dispatchDisconnected(t.mailbox, t.listener, t)
 			return
 		}
 		for {
 			msg, err2 := t.hd.Decode(t.receive)
 			if err2 != nil {
 				logger.Errorf("msg err: %s", err2.Error())
+				t.metrics.decodeError()
+				t.metrics.disconnect(DisconnectDecodeError)
 				t.close()
-				t.listener.OnDisconnected(t)
+				dispatchDisconnected(t.mailbox, t.listener, t)
 				return
 			}
-			if msg != nil {
-				t.listener.OnData(t, msg)
-			} else {
+			if msg == nil {
 				break
 			}
+			t.metrics.frame(msg)
+			t.opts.Capture.record(t, CaptureInbound, msg.Bytes())
+			pass, closed := t.limiter.check(t, msg.ReadableBytes())
+			if closed {
+				t.metrics.disconnect(DisconnectRateLimit)
+				t.close()
+				dispatchDisconnected(t.mailbox, t.listener, t)
+				return
+			}
+			if pass && !dispatchData(t.mailbox, t.listener, t, msg) {
+				t.metrics.disconnect(DisconnectMailboxFull)
+				t.close()
+				dispatchDisconnected(t.mailbox, t.listener, t)
+				return
+			}
 		}
 
 	}
 }
 
 func (t *TCPConn) run() {
+	//写空闲检测，未开启时idleC为nil永不触发
+	var idleTimer *time.Timer
+	var idleC <-chan time.Time
+	if t.opts.WriteIdle > 0 {
+		idleTimer = time.NewTimer(t.opts.WriteIdle)
+		idleC = idleTimer.C
+	}
 	defer func() {
+		close(t.queue.chDone)
+		if idleTimer != nil {
+			idleTimer.Stop()
+		}
 		if r := recover(); r != nil {
 			logger.TraceErr(r)
 			t.close()
@@ -111,20 +209,44 @@ func (t *TCPConn) run() {
 	}()
 	for {
 		select {
-		case msg, ok := <-t.chData:
+		case msg, ok := <-t.queue.chData:
 			if !ok || msg == nil {
 				logger.Infof("msg close:%s", t.Ip())
 				t.close()
 				return
 			}
-			if msg != nil && t.connected {
-				_, err := t.conn.Write(msg)
+			closing := false
+			if t.connectAtomic.Get() == 0 {
+				var err error
+				closing, err = t.writeBatch(msg)
 				if err != nil {
 					logger.Errorf(" Write:%s", err.Error())
+					t.metrics.disconnect(DisconnectWriteError)
+					t.close()
+					return
+				}
+			}
+			if closing {
+				logger.Infof("msg close:%s", t.Ip())
+				t.close()
+				return
+			}
+			if idleTimer != nil {
+				idleTimer.Reset(t.opts.WriteIdle)
+			}
+		case <-idleC:
+			if t.opts.Heartbeat != nil {
+				if _, err := t.conn.Write(t.opts.Heartbeat); err != nil {
+					logger.Errorf(" Heartbeat:%s", err.Error())
+					t.metrics.disconnect(DisconnectWriteError)
 					t.close()
 					return
 				}
+				t.metrics.write(1, len(t.opts.Heartbeat))
 			}
+			//回调中可能写入数据，不在写协程中执行
+			go t.idle(WriterIdle)
+			idleTimer.Reset(t.opts.WriteIdle)
 		case <-t.chStopWrite:
 			logger.Infof("chStopWrite destroy:%s", t.Ip())
 			return
@@ -132,6 +254,70 @@ func (t *TCPConn) run() {
 	}
 }
 
+// writeBatch 取出队列中已有的消息一起写入，减少系统调用，队列中取到关闭标记时返回closing
+func (t *TCPConn) writeBatch(msg []byte) (closing bool, err error) {
+	batch := append(t.batch[:0], msg)
+	size := len(msg)
+loop:
+	for size < t.opts.MaxBatchBytes {
+		select {
+		case next, ok := <-t.queue.chData:
+			if !ok || next == nil {
+				closing = true
+				break loop
+			}
+			batch = append(batch, next)
+			size += len(next)
+		default:
+			break loop
+		}
+	}
+	if len(batch) == 1 {
+		_, err = t.conn.Write(msg)
+	} else if t.writev {
+		buffers := net.Buffers(batch)
+		_, err = buffers.WriteTo(t.conn)
+	} else {
+		//tls等不支持writev的连接合并后写入
+		t.coalesce = t.coalesce[:0]
+		for _, b := range batch {
+			t.coalesce = append(t.coalesce, b...)
+		}
+		_, err = t.conn.Write(t.coalesce)
+	}
+	t.writeStats.add(len(batch), size)
+	t.metrics.write(len(batch), size)
+	t.metrics.batch(len(batch), size)
+	clear(batch)
+	t.batch = batch[:0]
+	return closing, err
+}
+
+// WriteStats 连接的批量写入统计
+func (t *TCPConn) WriteStats() WriteStats {
+	return t.writeStats.snapshot()
+}
+
+// idle 空闲通知
+func (t *TCPConn) idle(state IdleState) {
+	if l, ok := t.listener.(SocketIdleListener); ok {
+		l.OnIdle(t, state)
+		return
+	}
+	defaultIdle(t, state)
+}
+
+// reject 拒绝连接，只启动写协程，通知后发送完队列中的数据再关闭
+func (t *TCPConn) reject(reason RejectReason) {
+	t.metrics.reject()
+	logger.Infof("reject conn:%s,reason:%d", t.Ip(), reason)
+	go t.run()
+	if t.opts.OnReject != nil {
+		t.opts.OnReject(t, reason)
+	}
+	t.Close()
+}
+
 // Destroy 目标通知断开后销毁
 func (t *TCPConn) Destroy() {
 	t.doDestroy()
@@ -139,21 +325,19 @@ func (t *TCPConn) Destroy() {
 
 // WriteAndFlush 向目标发送数据
 func (t *TCPConn) WriteAndFlush(msg []byte) {
-	if t.closeData {
+	if t.queue.closed() {
 		return
 	}
+	t.opts.Capture.record(t, CaptureOutbound, msg)
 	t.doWrite(msg)
 }
 
 // Close 主动关闭连接（调用前先向目标发送关闭信息）
 func (t *TCPConn) Close() {
-	t.closeMutex.Lock()
-	defer t.closeMutex.Unlock()
-	if t.closeData {
+	if !t.queue.closeFlag.CompareAndSet(0, 1) {
 		return
 	}
-	t.chData <- nil
-	t.closeData = true
+	t.doWrite(nil)
 }
 
 func (t *TCPConn) close() {
@@ -161,38 +345,48 @@ func (t *TCPConn) close() {
 	if !t.connectAtomic.CompareAndSet(0, 1) {
 		return
 	}
-	if !t.connected {
-		return
-	}
-	t.connected = false
+	t.metrics.closed()
+	t.opts.Capture.record(t, CaptureDisconnect, nil)
 	_ = t.conn.Close()
 }
 
+// closeWith 记录断开原因后断开
+func (t *TCPConn) closeWith(reason DisconnectReason) {
+	t.metrics.disconnect(reason)
+	t.close()
+}
+
 func (t *TCPConn) doDestroy() {
 	t.close()
+	t.queue.closeFlag.Set(1)
 	t.closeMutex.Lock()
 	defer t.closeMutex.Unlock()
-	if t.closeData {
+	if t.stopWrite {
 		return
 	}
 	close(t.chStopWrite)
-	t.closeData = true
+	t.stopWrite = true
 }
 
+// doWrite 写入队列，队列已满时按配置的策略处理
 func (t *TCPConn) doWrite(msg []byte) {
-	select {
-	case t.chData <- msg:
-	default:
-		t.full(msg)
+	if t.queue.push(t, msg) {
+		t.metrics.disconnect(DisconnectBackpressure)
+		t.close()
 	}
 }
 
-func (t *TCPConn) full(msg []byte) {
-	logger.Infof("队列已满进行等待,ip:%s,len:%d", t.Ip(), len(t.chData))
-	t.closeMutex.Lock()
-	defer t.closeMutex.Unlock()
-	if t.closeData {
-		return
-	}
-	t.chData <- msg
+// BackpressureStats 连接写入队列已满的统计
+func (t *TCPConn) BackpressureStats() BackpressureStats {
+	return t.queue.counter.snapshot()
+}
+
+// Stats 连接的流量统计
+func (t *TCPConn) Stats() ConnStats {
+	return t.metrics.snapshot(t.id, t.Ip(), t.queue)
+}
+
+// DisconnectReason 断开原因，未断开时为0
+func (t *TCPConn) DisconnectReason() DisconnectReason {
+	return DisconnectReason(t.metrics.reason.Get())
 }
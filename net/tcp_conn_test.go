@@ -0,0 +1,133 @@
+package net
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type idleListener struct {
+	testListener
+	idleMutex sync.Mutex
+	states    []IdleState
+}
+
+func (l *idleListener) OnIdle(conn Channel, state IdleState) {
+	l.idleMutex.Lock()
+	l.states = append(l.states, state)
+	l.idleMutex.Unlock()
+	if state == ReaderIdle {
+		conn.Close()
+	}
+}
+
+func (l *idleListener) stateCount() int {
+	l.idleMutex.Lock()
+	defer l.idleMutex.Unlock()
+	return len(l.states)
+}
+
+func TestTCPConnReadIdleClose(t *testing.T) {
+	l := &testListener{}
+	addr := freeAddr(t)
+	server := NewTCPServer(addr, l, WithReadIdle(100*time.Millisecond))
+	go server.Listen()
+	defer server.Close()
+	conn := dialRetry(t, addr)
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	waitFor(t, func() bool { return l.disconnectedCount() == 1 })
+	//空闲断开单独统计，不计为读错误或本端关闭
+	if stats := server.TrafficStats(); stats.Disconnects[DisconnectIdle] != 1 || len(stats.Disconnects) != 1 {
+		t.Fatalf("disconnects %v", stats.Disconnects)
+	}
+}
+
+func TestTCPConnReadIdleKeepAlive(t *testing.T) {
+	l := &idleListener{}
+	addr := freeAddr(t)
+	server := NewTCPServer(addr, l, WithReadIdle(150*time.Millisecond))
+	go server.Listen()
+	defer server.Close()
+	conn := dialRetry(t, addr)
+	defer conn.Close()
+
+	//持续发送数据不会触发读空闲
+	for i := 0; i < 5; i++ {
+		_, _ = conn.Write(frame([]byte("ping")))
+		time.Sleep(50 * time.Millisecond)
+	}
+	if l.stateCount() != 0 || l.disconnectedCount() != 0 {
+		t.Fatal("unexpected idle")
+	}
+	waitFor(t, func() bool { return l.disconnectedCount() == 1 })
+	if l.stateCount() != 1 || l.states[0] != ReaderIdle {
+		t.Fatalf("states %v", l.states)
+	}
+}
+
+func TestTCPConnHeartbeat(t *testing.T) {
+	l := &idleListener{}
+	addr := freeAddr(t)
+	server := NewTCPServer(addr, l, WithHeartbeat(50*time.Millisecond, frame([]byte("hb"))))
+	go server.Listen()
+	defer server.Close()
+	conn := dialRetry(t, addr)
+	defer conn.Close()
+
+	for i := 0; i < 2; i++ {
+		if got := string(readFrame(t, conn)); got != "hb" {
+			t.Fatalf("got %q", got)
+		}
+	}
+	waitFor(t, func() bool { return l.stateCount() >= 2 })
+}
+
+func TestTCPConnBatchWrite(t *testing.T) {
+	const count = 50
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeServer, pipeClient := net.Pipe()
+	defer pipeClient.Close()
+
+	//tcp使用writev，pipe合并后写入
+	for _, c := range []struct {
+		server net.Conn
+		client net.Conn
+	}{{server, client}, {pipeServer, pipeClient}} {
+		tcpConn := newTcpConn(c.server, &testListener{}, 1024, newOptions())
+		//写协程启动前写入队列，模拟广播堆积
+		for i := 0; i < count; i++ {
+			tcpConn.WriteAndFlush(frame([]byte("batch")))
+		}
+		tcpConn.Close()
+		go tcpConn.run()
+		for i := 0; i < count; i++ {
+			if got := string(readFrame(t, c.client)); got != "batch" {
+				t.Fatalf("frame %d: %q", i, got)
+			}
+		}
+		waitFor(t, func() bool { return tcpConn.WriteStats().Messages == count })
+		if stats := tcpConn.WriteStats(); stats.Writes != 1 || stats.Bytes != count*9 {
+			t.Fatalf("stats %+v", stats)
+		}
+	}
+}
@@ -1,8 +1,13 @@
 package net
 
 import (
+	"context"
+	"crypto/tls"
 	"github.com/yhhaiua/engine/log"
+	"github.com/yhhaiua/engine/util"
 	"net"
+	"os"
+	"sync"
 	"time"
 )
 
@@ -13,35 +18,54 @@ type TCPServer struct {
 	listener SocketListener
 	ln       net.Listener
 	length   int
+	hub      *serverListener
+	opts     *Options
+	closing  util.AtomicInteger
+	proxy    *proxyParser
+	mutex    sync.Mutex
 }
 
-func NewTCPServer(addr string, listener SocketListener) *TCPServer {
-	server := &TCPServer{
-		addr:     addr,
-		listener: listener,
-		length:   327670,
-	}
-	return server
+func NewTCPServer(addr string, listener SocketListener, opts ...Option) *TCPServer {
+	return NewTCPServerLength(addr, listener, 327670, opts...)
 }
-func NewTCPServerLength(addr string, listener SocketListener, length int) *TCPServer {
+func NewTCPServerLength(addr string, listener SocketListener, length int, opts ...Option) *TCPServer {
+	o := newOptions(opts...)
 	server := &TCPServer{
 		addr:     addr,
 		listener: listener,
 		length:   length,
+		hub:      newServerListener(listener, o),
+		opts:     o,
 	}
 	return server
 }
 
 func (server *TCPServer) Listen() {
 
-	lister, err := net.Listen("tcp", server.addr)
+	if server.opts.Network == "unix" {
+		removeStaleSocket(server.addr)
+	}
+	lister, err := net.Listen(server.opts.Network, server.addr)
 
 	if err != nil {
 		logger.Errorf("Listen error:%s", err.Error())
 		return
 	}
-	logger.Infof("tcp success:%s", server.addr)
+	if server.opts.ProxyProtocol != nil {
+		server.proxy, err = newProxyParser(server.opts.ProxyProtocol, server.opts.Network)
+		if err != nil {
+			_ = lister.Close()
+			logger.Errorf("proxy protocol error:%s", err.Error())
+			return
+		}
+	} else if server.opts.TLSConfig != nil {
+		//PROXY头在TLS握手之前，开启时读取PROXY头后再握手
+		lister = tls.NewListener(lister, server.opts.TLSConfig)
+	}
+	logger.Infof("%s success:%s", server.opts.Network, server.addr)
+	server.mutex.Lock()
 	server.ln = lister
+	server.mutex.Unlock()
 	server.run()
 }
 
@@ -51,6 +75,10 @@ func (server *TCPServer) run() {
 	for {
 		conn, err := server.ln.Accept()
 		if err != nil {
+			if server.closing.Get() == 1 {
+				logger.Infof("tcp server closed:%s", server.addr)
+				return
+			}
 			if ne, ok := err.(net.Error); ok && ne.Temporary() {
 				if tempDelay == 0 {
 					tempDelay = 5 * time.Millisecond
@@ -68,15 +96,117 @@ func (server *TCPServer) run() {
 			return
 		}
 		tempDelay = 0
-		tcpConn := newTcpConn(conn, server.listener, server.length)
-		if tcpConn != nil {
-			tcpConn.start()
+		if server.proxy != nil || server.opts.Secure != nil {
+			//读取PROXY头和加密握手不阻塞accept
+			go server.accept(conn)
+			continue
+		}
+		server.accept(conn)
+
+	}
+}
+
+// accept 检查来源并读取PROXY头，检查连接数限制后开启加密时握手
+func (server *TCPServer) accept(conn net.Conn) {
+	var addr net.Addr
+	if server.proxy != nil {
+		if !server.proxy.trust(conn.RemoteAddr()) {
+			logger.Warnf("untrusted proxy source close:%s", conn.RemoteAddr().String())
+			_ = conn.Close()
+			return
+		}
+		var err error
+		addr, err = server.proxy.read(conn)
+		if err != nil {
+			logger.Errorf("proxy protocol close:%s,err:%s", conn.RemoteAddr().String(), err.Error())
+			_ = conn.Close()
+			return
+		}
+		if server.opts.TLSConfig != nil {
+			conn = tls.Server(conn, server.opts.TLSConfig)
+		}
+	}
+	remote := conn.RemoteAddr()
+	if addr != nil {
+		remote = addr
+	}
+	ip := addrIp(remote)
+	if reason, ok := server.hub.admit(ip); !ok {
+		server.reject(conn, addr, reason)
+		return
+	}
+	if server.opts.Secure != nil {
+		var err error
+		if conn, err = newSecureConn(conn, server.opts.Secure, true); err != nil {
+			logger.Errorf("secure handshake close:%s,err:%s", remote.String(), err.Error())
+			server.hub.release(ip)
+			return
 		}
+	}
+	tcpConn := newTcpConn(conn, server.hub, server.length, server.opts)
+	if tcpConn == nil {
+		_ = conn.Close()
+		server.hub.release(ip)
+		return
+	}
+	tcpConn.proxyAddr = addr
+	tcpConn.start()
+}
 
+// reject 超过连接数限制，回调OnReject后断开，开启加密时未握手无法发送消息，直接断开
+func (server *TCPServer) reject(conn net.Conn, proxyAddr net.Addr, reason RejectReason) {
+	if server.opts.Secure != nil {
+		logger.Infof("reject conn:%s,reason:%d", conn.RemoteAddr().String(), reason)
+		_ = conn.Close()
+		return
+	}
+	tcpConn := newTcpConn(conn, server.hub, server.length, server.opts)
+	if tcpConn == nil {
+		_ = conn.Close()
+		return
 	}
+	tcpConn.proxyAddr = proxyAddr
+	go tcpConn.reject(reason)
 }
+
 func (server *TCPServer) Close() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
 	if server.ln != nil {
 		_ = server.ln.Close()
 	}
 }
+
+// Shutdown 优雅关闭：停止接收新连接，通知所有连接并发送完队列中的数据，等待连接全部断开或ctx超时
+func (server *TCPServer) Shutdown(ctx context.Context) error {
+	server.closing.Set(1)
+	server.Close()
+	return server.hub.shutdown(ctx)
+}
+
+// Registry 存活连接管理
+func (server *TCPServer) Registry() *Registry {
+	return server.hub.registry
+}
+
+// WriteStats 所有连接的批量写入统计
+func (server *TCPServer) WriteStats() WriteStats {
+	return server.opts.writeStats.snapshot()
+}
+
+// BackpressureStats 所有连接写入队列已满的统计
+func (server *TCPServer) BackpressureStats() BackpressureStats {
+	return server.opts.backpressure.snapshot()
+}
+
+// removeStaleSocket 删除进程异常退出后残留的unix socket文件
+func removeStaleSocket(path string) {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+}
+
+// TrafficStats 所有连接的流量汇总，包括已断开的连接
+func (server *TCPServer) TrafficStats() TrafficStats {
+	return server.opts.traffic.snapshot()
+}
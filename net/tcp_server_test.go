@@ -0,0 +1,264 @@
+package net
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/yhhaiua/engine/buffer"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testListener struct {
+	mutex        sync.Mutex
+	connected    []Channel
+	disconnected []Channel
+	data         [][]byte
+	onShutdown   func(conn Channel)
+	onData       func(conn Channel, msg *buffer.ByteBuf)
+}
+
+func (l *testListener) OnConnected(conn Channel) {
+	l.mutex.Lock()
+	l.connected = append(l.connected, conn)
+	l.mutex.Unlock()
+}
+
+func (l *testListener) OnDisconnected(conn Channel) {
+	l.mutex.Lock()
+	l.disconnected = append(l.disconnected, conn)
+	l.mutex.Unlock()
+	conn.Destroy()
+}
+
+func (l *testListener) OnData(conn Channel, msg *buffer.ByteBuf) {
+	l.mutex.Lock()
+	l.data = append(l.data, append([]byte(nil), msg.Bytes()...))
+	l.mutex.Unlock()
+	if l.onData != nil {
+		l.onData(conn, msg)
+	}
+}
+
+func (l *testListener) OnShutdown(conn Channel) {
+	if l.onShutdown != nil {
+		l.onShutdown(conn)
+	}
+}
+
+func (l *testListener) connectedCount() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return len(l.connected)
+}
+
+func (l *testListener) disconnectedCount() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return len(l.disconnected)
+}
+
+func (l *testListener) dataCount() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return len(l.data)
+}
+
+// freeAddr 获取一个可用的本地地址
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+	return addr
+}
+
+// dialRetry 等待服务器启动后连接
+func dialRetry(t *testing.T, addr string) net.Conn {
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			return conn
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("dial %s failed", addr)
+	return nil
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("condition not reached")
+}
+
+// frame 按4字节长度头封包
+func frame(body []byte) []byte {
+	b := make([]byte, 4+len(body))
+	binary.BigEndian.PutUint32(b, uint32(len(body)))
+	copy(b[4:], body)
+	return b
+}
+
+func readFrame(t *testing.T, conn net.Conn) []byte {
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, binary.BigEndian.Uint32(head))
+	if _, err := io.ReadFull(conn, body); err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestTCPServerShutdown(t *testing.T) {
+	l := &testListener{}
+	l.onShutdown = func(conn Channel) {
+		conn.WriteAndFlush(frame([]byte("closing")))
+	}
+	addr := freeAddr(t)
+	server := NewTCPServer(addr, l)
+	go server.Listen()
+	conn := dialRetry(t, addr)
+	defer conn.Close()
+	waitFor(t, func() bool { return l.connectedCount() == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(readFrame(t, conn)); got != "closing" {
+		t.Fatalf("got %q", got)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	if l.disconnectedCount() != 1 {
+		t.Fatalf("disconnected %d", l.disconnectedCount())
+	}
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Fatal("server still accepting")
+	}
+}
+
+func TestTCPServerShutdownTimeout(t *testing.T) {
+	l := &testListener{}
+	//不断写入，保证队列不为空
+	l.onShutdown = func(conn Channel) {
+		for i := 0; i < TcpDataLength; i++ {
+			conn.WriteAndFlush(frame(make([]byte, 64*1024)))
+		}
+	}
+	addr := freeAddr(t)
+	server := NewTCPServer(addr, l)
+	go server.Listen()
+	conn := dialRetry(t, addr)
+	defer conn.Close()
+	waitFor(t, func() bool { return l.connectedCount() == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline, got %v", err)
+	}
+	waitFor(t, func() bool { return l.disconnectedCount() == 1 })
+}
+
+func TestTCPServerConnLimit(t *testing.T) {
+	l := &testListener{}
+	var mutex sync.Mutex
+	var reasons []RejectReason
+	addr := freeAddr(t)
+	server := NewTCPServer(addr, l, WithConnLimit(2, 1, func(conn Channel, reason RejectReason) {
+		mutex.Lock()
+		reasons = append(reasons, reason)
+		mutex.Unlock()
+		conn.WriteAndFlush(frame([]byte("full")))
+	}))
+	go server.Listen()
+	defer server.Close()
+	conn1 := dialRetry(t, addr)
+	defer conn1.Close()
+	waitFor(t, func() bool { return l.connectedCount() == 1 })
+
+	//同一ip超过限制
+	conn2 := dialRetry(t, addr)
+	defer conn2.Close()
+	if got := string(readFrame(t, conn2)); got != "full" {
+		t.Fatalf("got %q", got)
+	}
+	if _, err := conn2.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	mutex.Lock()
+	if len(reasons) != 1 || reasons[0] != RejectMaxConnsPerIP {
+		t.Fatalf("reasons %v", reasons)
+	}
+	mutex.Unlock()
+	if l.connectedCount() != 1 || l.disconnectedCount() != 0 {
+		t.Fatal("rejected connection notified listener")
+	}
+
+	//断开后释放名额
+	_ = conn1.Close()
+	waitFor(t, func() bool { return l.disconnectedCount() == 1 })
+	conn3 := dialRetry(t, addr)
+	defer conn3.Close()
+	waitFor(t, func() bool { return l.connectedCount() == 2 })
+}
+
+func TestTCPServerMaxConns(t *testing.T) {
+	l := &testListener{}
+	rejected := make(chan RejectReason, 1)
+	addr := freeAddr(t)
+	server := NewTCPServer(addr, l, WithConnLimit(1, 0, func(conn Channel, reason RejectReason) {
+		rejected <- reason
+		conn.WriteAndFlush(frame([]byte("full")))
+	}))
+	go server.Listen()
+	defer server.Close()
+	conn1 := dialRetry(t, addr)
+	defer conn1.Close()
+	waitFor(t, func() bool { return l.connectedCount() == 1 })
+
+	//超过服务器最大连接数
+	conn2 := dialRetry(t, addr)
+	defer conn2.Close()
+	if got := string(readFrame(t, conn2)); got != "full" {
+		t.Fatalf("got %q", got)
+	}
+	if reason := <-rejected; reason != RejectMaxConns {
+		t.Fatalf("reason %v", reason)
+	}
+	if _, err := conn2.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	//被拒绝的连接不计入流量统计
+	stats := server.TrafficStats()
+	if stats.Connects != 1 || len(stats.Disconnects) != 0 || stats.FramesOut != 0 || stats.QueueHighWater != 0 {
+		t.Fatalf("stats %+v", stats)
+	}
+	if writes := server.WriteStats(); writes != (WriteStats{}) {
+		t.Fatalf("write stats %+v", writes)
+	}
+
+	_ = conn1.Close()
+	waitFor(t, func() bool { return l.disconnectedCount() == 1 })
+	conn3 := dialRetry(t, addr)
+	defer conn3.Close()
+	waitFor(t, func() bool { return l.connectedCount() == 2 })
+	if stats = server.TrafficStats(); stats.Connects != 2 {
+		t.Fatalf("connects %d", stats.Connects)
+	}
+}
@@ -16,11 +16,12 @@ func NewTcpSession(channel Channel) *TcpSession {
 	return &TcpSession{channel: channel}
 }
 func (t *TcpSession) Post(cmd buffer.PacketCons) {
-	msg := buffer.NewByteBuf()
-	msg.WriteNInt32(0)
-	cmd.Write(msg)
-	msg.SetInt(0, msg.ReadableBytes()-4)
-	t.channel.WriteAndFlush(msg.Bytes())
+	t.channel.WriteAndFlush(channelEncoder(t.channel).Encode(cmd))
+}
+
+// EncodePacket 按4字节长度头编码消息，可用于一次编码多次发送
+func EncodePacket(cmd buffer.PacketCons) []byte {
+	return defaultEncoder.Encode(cmd)
 }
 
 type TcpSInterFace interface {
@@ -0,0 +1,153 @@
+package net
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert  *x509.Certificate
+	key   *ecdsa.PrivateKey
+	cFile string
+	kFile string
+}
+
+// newTestCert 生成证书，parent为空时自签名作为CA
+func newTestCert(t *testing.T, dir, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testCert{
+		cert:  cert,
+		key:   key,
+		cFile: filepath.Join(dir, name+".crt"),
+		kFile: filepath.Join(dir, name+".key"),
+	}
+	_ = os.WriteFile(c.cFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	_ = os.WriteFile(c.kFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return c
+}
+
+type runListener struct {
+	testListener
+}
+
+func (l *runListener) Run(conn Channel) {}
+
+func TestTCPMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	serverCert := newTestCert(t, dir, "server", ca)
+	clientCert := newTestCert(t, dir, "client", ca)
+
+	serverConfig, err := NewServerTLSConfig(serverCert.cFile, serverCert.kFile, ca.cFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConfig, err := NewClientTLSConfig(clientCert.cFile, clientCert.kFile, ca.cFile, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := &testListener{}
+	addr := freeAddr(t)
+	server := NewTCPServer(addr, l, WithTLS(serverConfig))
+	go server.Listen()
+	defer server.Close()
+	dialRetry(t, addr).Close()
+
+	cl := &runListener{}
+	client := NewTCPClient("tls", addr, cl, WithTLS(clientConfig))
+	client.Connect()
+	waitFor(t, func() bool { return cl.connectedCount() == 1 })
+	cl.connected[0].WriteAndFlush(frame([]byte("secure")))
+	waitFor(t, func() bool { return l.dataCount() == 1 })
+	if string(l.data[0]) != "secure" {
+		t.Fatalf("got %q", l.data[0])
+	}
+	cl.connected[0].Close()
+
+	//没有客户端证书无法通过校验
+	noCert, _ := NewClientTLSConfig("", "", ca.cFile, "localhost")
+	conn, err := tls.Dial("tcp", addr, noCert)
+	if err == nil {
+		defer conn.Close()
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err = conn.Read(make([]byte, 1)); err == nil {
+			t.Fatal("handshake without client certificate succeeded")
+		}
+	}
+	if l.dataCount() != 1 {
+		t.Fatal("unexpected data")
+	}
+}
+
+func TestWSServerTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	serverCert := newTestCert(t, dir, "server", ca)
+	serverConfig, err := NewServerTLSConfig(serverCert.cFile, serverCert.kFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConfig, _ := NewClientTLSConfig("", "", ca.cFile, "localhost")
+
+	l := &testListener{}
+	addr := freeAddr(t)
+	server := NewWSServer(addr, l, WithTLS(serverConfig))
+	go server.Listen()
+	defer server.Close()
+	dialRetry(t, addr).Close()
+
+	cl := &testListener{}
+	client := NewWsClient("wss://"+addr+"/", cl, WithTLS(clientConfig))
+	client.Start()
+	if cl.connectedCount() != 1 {
+		t.Fatal("wss connect failed")
+	}
+	cl.connected[0].WriteAndFlush([]byte("secure"))
+	waitFor(t, func() bool { return l.dataCount() == 1 })
+	if string(l.data[0]) != "secure" {
+		t.Fatalf("got %q", l.data[0])
+	}
+	cl.connected[0].Close()
+}
@@ -0,0 +1,178 @@
+package net
+
+import (
+	"github.com/yhhaiua/engine/buffer"
+	"github.com/yhhaiua/engine/util"
+	"net"
+	"sync"
+)
+
+// UDPClient 可靠udp客户端，udp无握手，连接超时（UDPConfig.Timeout）或重传失败后按Reconnect重连
+type UDPClient struct {
+	addr         string
+	listener     SocketListener
+	udpConn      *UDPConn
+	length       int
+	opts         *Options
+	mutex        sync.Mutex
+	chStop       chan struct{}
+	chLost       chan struct{}
+	stopped      bool
+	listenPacket func() (net.PacketConn, error)
+}
+
+func NewUDPClient(addr string, listener SocketListener, opts ...Option) *UDPClient {
+	return NewUDPClientLength(addr, listener, 327670, opts...)
+}
+func NewUDPClientLength(addr string, listener SocketListener, length int, opts ...Option) *UDPClient {
+	client := &UDPClient{
+		addr:     addr,
+		listener: listener,
+		length:   length,
+		opts:     newOptions(opts...),
+		listenPacket: func() (net.PacketConn, error) {
+			return net.ListenPacket("udp", ":0")
+		},
+	}
+	return client
+}
+
+func (client *UDPClient) OnConnected(conn Channel) {
+	client.listener.OnConnected(conn)
+}
+
+func (client *UDPClient) OnDisconnected(conn Channel) {
+	client.listener.OnDisconnected(conn)
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if client.udpConn == conn {
+		client.udpConn = nil
+		close(client.chLost)
+		client.chLost = nil
+	}
+}
+
+func (client *UDPClient) OnData(conn Channel, msg *buffer.ByteBuf) {
+	client.listener.OnData(conn, msg)
+}
+
+func (client *UDPClient) OnIdle(conn Channel, state IdleState) {
+	if l, ok := client.listener.(SocketIdleListener); ok {
+		l.OnIdle(conn, state)
+		return
+	}
+	defaultIdle(conn, state)
+}
+
+// UdpConn 当前连接，未连接时为nil
+func (client *UDPClient) UdpConn() *UDPConn {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.udpConn
+}
+
+// Start 立即连接一次并返回错误（地址解析或创建socket失败），之后在后台断线重连，直到Stop
+func (client *UDPClient) Start() error {
+	client.mutex.Lock()
+	if client.chStop != nil || client.stopped {
+		client.mutex.Unlock()
+		return nil
+	}
+	chStop := make(chan struct{})
+	client.chStop = chStop
+	client.mutex.Unlock()
+
+	conn, chLost, err := client.connect()
+	go func() {
+		if err == nil {
+			if client.serve(conn, chLost, chStop) {
+				return
+			}
+		}
+		client.opts.Reconnect.loop(client.addr, chStop, err, err == nil, client.connect, func(conn Channel, chLost chan struct{}) bool {
+			return client.serve(conn, chLost, chStop)
+		})
+	}()
+	return err
+}
+
+// serve 等待连接断开，返回true表示已停止
+func (client *UDPClient) serve(conn Channel, chLost, chStop chan struct{}) bool {
+	select {
+	case <-chStop:
+		conn.Close()
+		return true
+	case <-chLost:
+		return false
+	}
+}
+
+// connect 创建socket和连接，返回连接及断开通知
+func (client *UDPClient) connect() (Channel, chan struct{}, error) {
+	addr, err := net.ResolveUDPAddr("udp", client.addr)
+	if err != nil {
+		logger.Errorf("udp resolve err: %s", err.Error())
+		return nil, nil, err
+	}
+	pconn, err := client.listenPacket()
+	if err != nil {
+		logger.Errorf("udp listen err: %s", err.Error())
+		return nil, nil, err
+	}
+	conv := uint32(util.Uint64())
+	udpConn := newUDPConn(pconn, addr, conv, client, client.length, client.opts, func(t *UDPConn) {
+		_ = pconn.Close()
+	})
+	if udpConn == nil {
+		_ = pconn.Close()
+		return nil, nil, errCreateConn
+	}
+	logger.Infof("udp connect:%s", client.addr)
+	chLost := make(chan struct{})
+	client.mutex.Lock()
+	client.udpConn = udpConn
+	client.chLost = chLost
+	client.mutex.Unlock()
+	udpConn.start()
+	go client.read(pconn, addr, udpConn)
+	return udpConn, chLost, nil
+}
+
+// read 接收服务器的udp包，socket关闭后退出
+func (client *UDPClient) read(pconn net.PacketConn, addr *net.UDPAddr, udpConn *UDPConn) {
+	buf := make([]byte, udpPacketMax)
+	for {
+		n, from, err := pconn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			udpConn.close()
+			return
+		}
+		if n < arqOverhead || from.String() != addr.String() {
+			continue
+		}
+		udpConn.input(append([]byte(nil), buf[:n]...))
+	}
+}
+
+// Stop 停止重连并关闭当前连接
+func (client *UDPClient) Stop() {
+	client.mutex.Lock()
+	if client.stopped {
+		client.mutex.Unlock()
+		return
+	}
+	client.stopped = true
+	chStop := client.chStop
+	client.mutex.Unlock()
+	if chStop != nil {
+		close(chStop)
+	}
+}
+
+// Close 同Stop
+func (client *UDPClient) Close() {
+	client.Stop()
+}
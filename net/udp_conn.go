@@ -0,0 +1,432 @@
+package net
+
+import (
+	"encoding/binary"
+	"github.com/yhhaiua/engine/buffer"
+	"github.com/yhhaiua/engine/handler"
+	"github.com/yhhaiua/engine/util"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	UdpDataLength      = 100
+	udpInputLength     = 256 //每个连接等待处理的udp包数，超过后丢弃（由对方重传）
+	udpPacketMax       = 65535
+	udpDefaultMTU      = 1400
+	udpDefaultWindow   = 128
+	udpDefaultInterval = 20 * time.Millisecond
+	udpDefaultDeadLink = 20
+	udpDefaultTimeout  = 30 * time.Second
+	udpLinger          = 3 * time.Second //Close后等待已发送数据被确认的最长时间
+)
+
+// UDPConfig 可靠udp（ARQ）配置，零值字段使用默认值。
+// 单条消息最多分为min(RecvWindow,255)个分片，最大长度见MaxMessage（默认176128字节），超过的消息丢弃，不断开连接
+type UDPConfig struct {
+	MTU          int           //udp包最大长度，默认1400
+	SendWindow   int           //发送窗口（分片数），默认128
+	RecvWindow   int           //接收窗口（分片数），默认128
+	Interval     time.Duration //内部刷新周期，默认20ms
+	NoDelay      bool          //极速模式：最小rto 30ms，超时重传时rto增长1.5倍而不是翻倍
+	FastResend   int           //分片被跳过确认多少次后快速重传，0关闭
+	NoCongestion bool          //关闭拥塞控制，只受发送窗口和对方接收窗口限制
+	DeadLink     int           //单个分片重传多少次后断开连接，默认20
+	Timeout      time.Duration //多久未收到对方数据包断开连接，默认30s，空闲时每Timeout/3发送保活
+}
+
+// UDPNormal 普通模式，有拥塞控制，适合带宽敏感的场景
+func UDPNormal() UDPConfig {
+	return UDPConfig{}
+}
+
+// UDPFast 极速模式，开启NoDelay、2次快速重传、关闭拥塞控制，10ms刷新，以带宽换取延迟
+func UDPFast() UDPConfig {
+	return UDPConfig{
+		Interval:     10 * time.Millisecond,
+		NoDelay:      true,
+		FastResend:   2,
+		NoCongestion: true,
+	}
+}
+
+// MaxMessage 单条消息（编码后）最大长度，两端配置需一致
+func (c UDPConfig) MaxMessage() int {
+	c = c.withDefaults()
+	return min(c.RecvWindow, arqFragmentMax) * (c.MTU - arqOverhead)
+}
+
+// withDefaults 填充默认值
+func (c UDPConfig) withDefaults() UDPConfig {
+	if c.MTU <= arqOverhead {
+		c.MTU = udpDefaultMTU
+	}
+	if c.SendWindow <= 0 {
+		c.SendWindow = udpDefaultWindow
+	}
+	if c.RecvWindow <= 0 {
+		c.RecvWindow = udpDefaultWindow
+	}
+	if c.Interval <= 0 {
+		c.Interval = udpDefaultInterval
+	}
+	if c.DeadLink <= 0 {
+		c.DeadLink = udpDefaultDeadLink
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = udpDefaultTimeout
+	}
+	return c
+}
+
+// UDPConn 可靠udp连接。写协程持有arq，负责发送、接收udp包和定时刷新，读协程解码并回调OnData
+type UDPConn struct {
+	pconn         net.PacketConn
+	addr          net.Addr
+	conv          uint32
+	arq           *arq
+	config        UDPConfig
+	receive       *buffer.ByteBuf
+	listener      SocketListener
+	hd            handler.Handler
+	encoder       handler.Encoder
+	queue         *writeQueue
+	chInput       chan []byte
+	chRecv        chan []byte
+	chStopWrite   chan struct{}
+	connectAtomic util.AtomicInteger
+	id            int64
+	opts          *Options
+	mailbox       *mailbox
+	limiter       *rateLimiter
+	onClose       func(t *UDPConn)
+	lastSend      time.Time
+	statsMutex    sync.Mutex
+	stats         ArqStats
+	attrs         Attrs
+	metrics       connMetrics
+}
+
+func (t *UDPConn) Id() int64 {
+	return t.id
+}
+
+func (t *UDPConn) Ip() string {
+	host, _, err := net.SplitHostPort(t.addr.String())
+	if err != nil {
+		return t.addr.String()
+	}
+	return host
+}
+
+// Attrs 连接属性
+func (t *UDPConn) Attrs() *Attrs {
+	return &t.attrs
+}
+
+// Encoder 连接使用的消息编码器
+func (t *UDPConn) Encoder() handler.Encoder {
+	return t.encoder
+}
+
+// newUDPConn 创建连接，pconn由服务器的所有连接共享，onClose在连接关闭时调用
+func newUDPConn(pconn net.PacketConn, addr net.Addr, conv uint32, listener SocketListener, length int, opts *Options, onClose func(t *UDPConn)) *UDPConn {
+	t := new(UDPConn)
+	t.pconn = pconn
+	t.addr = addr
+	t.conv = conv
+	t.opts = opts
+	t.config = opts.UDP.withDefaults()
+	t.listener = listener
+	t.receive = buffer.NewByteBuf()
+	t.queue = newWriteQueue(UdpDataLength, opts, &t.metrics)
+	t.chInput = make(chan []byte, udpInputLength)
+	t.chRecv = make(chan []byte, UdpDataLength)
+	t.chStopWrite = make(chan struct{})
+	t.onClose = onClose
+	var hd handler.Handler
+	var err error
+	if opts.NewHandler != nil {
+		hd, err = opts.NewHandler()
+	} else {
+		hd, err = handler.NewLengthDecoderClient(length)
+	}
+	if err != nil {
+		logger.Errorf("new UDPConn err: %s", err.Error())
+		return nil
+	}
+	t.hd = hd
+	t.encoder = opts.encoder()
+	t.limiter = newRateLimiter(opts.RateLimit)
+	if opts.Serial {
+		t.mailbox = newMailbox(opts.MailboxLimit)
+	}
+	t.arq = newArq(conv, &t.config, t.output)
+	t.id = globalId.IncrementAndGet()
+	t.metrics.init(opts)
+	return t
+}
+
+func (t *UDPConn) start() {
+	t.metrics.start()
+	t.listener.OnConnected(t)
+	go t.read()
+	go t.run()
+}
+
+// input 收到对方的udp包，等待处理的包过多时丢弃
+func (t *UDPConn) input(packet []byte) {
+	select {
+	case t.chInput <- packet:
+	default:
+	}
+}
+
+func (t *UDPConn) output(buf []byte) {
+	t.lastSend = time.Now()
+	if _, err := t.pconn.WriteTo(buf, t.addr); err != nil {
+		logger.Errorf("udp write err: %s", err.Error())
+	}
+}
+
+// read 解码写协程收到的完整消息，chRecv关闭（写协程退出）后通知断开
+func (t *UDPConn) read() {
+
+	defer func() {
+		if r := recover(); r != nil {
+			logger.TraceErr(r)
+		}
+		t.close()
+		dispatchDisconnected(t.mailbox, t.listener, t)
+	}()
+	for data := range t.chRecv {
+		t.metrics.read(len(data))
+		_, _ = t.receive.Write(data)
+		for {
+			msg, err := t.hd.Decode(t.receive)
+			if err != nil {
+				logger.Errorf("msg err: %s", err.Error())
+				t.metrics.decodeError()
+				t.metrics.disconnect(DisconnectDecodeError)
+				return
+			}
+			if msg == nil {
+				break
+			}
+			t.metrics.frame(msg)
+			pass, closed := t.limiter.check(t, msg.ReadableBytes())
+			if closed {
+				t.metrics.disconnect(DisconnectRateLimit)
+				return
+			}
+			if pass && !dispatchData(t.mailbox, t.listener, t, msg) {
+				t.metrics.disconnect(DisconnectMailboxFull)
+				return
+			}
+		}
+	}
+}
+
+func (t *UDPConn) run() {
+	ticker := time.NewTicker(t.config.Interval)
+	defer func() {
+		ticker.Stop()
+		close(t.queue.chDone)
+		close(t.chRecv)
+		if r := recover(); r != nil {
+			logger.TraceErr(r)
+		}
+		t.close()
+	}()
+	now := time.Now()
+	t.lastSend = now
+	lastInput, lastRead, lastWrite := now, now, now
+	chData := t.queue.chData
+	var pending []byte
+	var linger time.Time
+	for {
+		//读协程处理不过来时不再取出消息，接收窗口缩小后对方停止发送
+		if pending == nil {
+			pending = t.arq.recv()
+		}
+		var chRecv chan<- []byte
+		if pending != nil {
+			chRecv = t.chRecv
+		}
+		//发送窗口堆积过多时不再取出写入队列，由Backpressure处理
+		chWrite := chData
+		if t.arq.waitSend() >= 2*t.config.SendWindow {
+			chWrite = nil
+		}
+		select {
+		case chRecv <- pending:
+			pending = nil
+			lastRead = time.Now()
+		case msg := <-chWrite:
+			if msg == nil {
+				//关闭标记，等待已发送数据被确认后关闭
+				chData = nil
+				linger = time.Now().Add(udpLinger)
+				continue
+			}
+			if err := t.arq.send(msg); err != nil {
+				//超过单条消息最大长度，丢弃消息不断开连接
+				logger.Errorf("udp send err: %s,len:%d,max:%d", err.Error(), len(msg), t.config.MaxMessage())
+				continue
+			}
+			t.metrics.write(1, len(msg))
+			lastWrite = time.Now()
+		case packet := <-t.chInput:
+			lastInput = time.Now()
+			if packet[4] == arqCmdFin {
+				logger.Infof("远程连接：%s,关闭", t.addr.String())
+				t.metrics.disconnect(DisconnectRemote)
+				return
+			}
+			if err := t.arq.input(packet); err != nil {
+				logger.Errorf("udp input err: %s", err.Error())
+				t.metrics.disconnect(DisconnectReadError)
+				return
+			}
+			if t.config.NoDelay {
+				t.arq.flush()
+			}
+		case now = <-ticker.C:
+			if now.Sub(lastInput) > t.config.Timeout {
+				logger.Infof("udp timeout close:%s", t.Ip())
+				t.metrics.disconnect(DisconnectIdle)
+				return
+			}
+			if t.opts.ReadIdle > 0 && now.Sub(lastRead) >= t.opts.ReadIdle {
+				lastRead = now
+				go t.idle(ReaderIdle)
+			}
+			if t.opts.WriteIdle > 0 && now.Sub(lastWrite) >= t.opts.WriteIdle {
+				lastWrite = now
+				if t.opts.Heartbeat != nil && t.arq.send(t.opts.Heartbeat) == nil {
+					t.metrics.write(1, len(t.opts.Heartbeat))
+				}
+				go t.idle(WriterIdle)
+			}
+			if now.Sub(t.lastSend) >= t.config.Timeout/3 {
+				t.arq.probe |= arqAskTell
+			}
+			t.arq.flush()
+			t.statsMutex.Lock()
+			t.stats = t.arq.stats()
+			t.statsMutex.Unlock()
+			if t.arq.dead {
+				logger.Infof("udp dead link close:%s", t.Ip())
+				t.metrics.disconnect(DisconnectWriteError)
+				return
+			}
+			if !linger.IsZero() && (t.arq.waitSend() == 0 || now.After(linger)) {
+				logger.Infof("msg close:%s", t.Ip())
+				t.sendFin()
+				return
+			}
+		case <-t.chStopWrite:
+			logger.Infof("chStopWrite destroy:%s", t.Ip())
+			return
+		}
+	}
+}
+
+// sendFin 通知对方关闭，丢失时对方超时断开
+func (t *UDPConn) sendFin() {
+	var fin [arqOverhead]byte
+	binary.LittleEndian.PutUint32(fin[0:], t.conv)
+	fin[4] = arqCmdFin
+	t.output(fin[:])
+}
+
+// idle 空闲通知
+func (t *UDPConn) idle(state IdleState) {
+	if l, ok := t.listener.(SocketIdleListener); ok {
+		l.OnIdle(t, state)
+		return
+	}
+	defaultIdle(t, state)
+}
+
+// reject 拒绝连接，只启动写协程，通知后发送完队列中的数据再关闭
+func (t *UDPConn) reject(reason RejectReason) {
+	t.metrics.reject()
+	logger.Infof("reject conn:%s,reason:%d", t.Ip(), reason)
+	go t.run()
+	if t.opts.OnReject != nil {
+		t.opts.OnReject(t, reason)
+	}
+	t.Close()
+}
+
+// Destroy 目标通知断开后销毁
+func (t *UDPConn) Destroy() {
+	t.queue.closeFlag.Set(1)
+	t.close()
+}
+
+// WriteAndFlush 向目标发送数据
+func (t *UDPConn) WriteAndFlush(msg []byte) {
+	if t.queue.closed() {
+		return
+	}
+	t.doWrite(msg)
+}
+
+// Close 主动关闭连接（等待已发送的数据被确认后通知对方关闭）
+func (t *UDPConn) Close() {
+	if !t.queue.closeFlag.CompareAndSet(0, 1) {
+		return
+	}
+	t.doWrite(nil)
+}
+
+// closeWith 记录断开原因后断开
+func (t *UDPConn) closeWith(reason DisconnectReason) {
+	t.metrics.disconnect(reason)
+	t.close()
+}
+
+func (t *UDPConn) close() {
+
+	if !t.connectAtomic.CompareAndSet(0, 1) {
+		return
+	}
+	t.metrics.closed()
+	close(t.chStopWrite)
+	if t.onClose != nil {
+		t.onClose(t)
+	}
+}
+
+// doWrite 写入队列，队列已满时按配置的策略处理
+func (t *UDPConn) doWrite(msg []byte) {
+	if t.queue.push(t, msg) {
+		t.metrics.disconnect(DisconnectBackpressure)
+		t.close()
+	}
+}
+
+// ArqStats 连接的重传统计，每个刷新周期更新
+func (t *UDPConn) ArqStats() ArqStats {
+	t.statsMutex.Lock()
+	defer t.statsMutex.Unlock()
+	return t.stats
+}
+
+// BackpressureStats 连接写入队列已满的统计
+func (t *UDPConn) BackpressureStats() BackpressureStats {
+	return t.queue.counter.snapshot()
+}
+
+// Stats 连接的流量统计
+func (t *UDPConn) Stats() ConnStats {
+	return t.metrics.snapshot(t.id, t.Ip(), t.queue)
+}
+
+// DisconnectReason 断开原因，未断开时为0
+func (t *UDPConn) DisconnectReason() DisconnectReason {
+	return DisconnectReason(t.metrics.reason.Get())
+}
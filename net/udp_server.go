@@ -0,0 +1,164 @@
+package net
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/yhhaiua/engine/util"
+	"net"
+	"sync"
+)
+
+// UDPServer 可靠udp服务器，按对方地址和conv区分连接，连接与tcp一样回调SocketListener
+type UDPServer struct {
+	addr     string
+	listener SocketListener
+	pconn    net.PacketConn
+	length   int
+	hub      *serverListener
+	opts     *Options
+	closing  util.AtomicInteger
+	mutex    sync.Mutex
+	sessions map[string]*UDPConn
+}
+
+func NewUDPServer(addr string, listener SocketListener, opts ...Option) *UDPServer {
+	return NewUDPServerLength(addr, listener, 327670, opts...)
+}
+func NewUDPServerLength(addr string, listener SocketListener, length int, opts ...Option) *UDPServer {
+	o := newOptions(opts...)
+	server := &UDPServer{
+		addr:     addr,
+		listener: listener,
+		length:   length,
+		hub:      newServerListener(listener, o),
+		opts:     o,
+		sessions: make(map[string]*UDPConn),
+	}
+	return server
+}
+
+func (server *UDPServer) Listen() {
+
+	pconn, err := net.ListenPacket("udp", server.addr)
+	if err != nil {
+		logger.Errorf("Listen error:%s", err.Error())
+		return
+	}
+	logger.Infof("udp success:%s", server.addr)
+	server.Serve(pconn)
+}
+
+// Serve 在已有的PacketConn上提供服务，阻塞直到关闭
+func (server *UDPServer) Serve(pconn net.PacketConn) {
+	server.mutex.Lock()
+	server.pconn = pconn
+	server.mutex.Unlock()
+	buf := make([]byte, udpPacketMax)
+	for {
+		n, addr, err := pconn.ReadFrom(buf)
+		if err != nil {
+			if server.closing.Get() == 1 {
+				logger.Infof("udp server closed:%s", server.addr)
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			logger.Errorf("udp read error: %s;", err.Error())
+			return
+		}
+		if n < arqOverhead {
+			continue
+		}
+		packet := append([]byte(nil), buf[:n]...)
+		if conn := server.session(pconn, addr, packet); conn != nil {
+			conn.input(packet)
+		}
+	}
+}
+
+// session 查找或创建连接，只有数据包可以创建新连接
+func (server *UDPServer) session(pconn net.PacketConn, addr net.Addr, packet []byte) *UDPConn {
+	conv := binary.LittleEndian.Uint32(packet)
+	key := addr.String()
+	server.mutex.Lock()
+	conn := server.sessions[key]
+	if conn != nil && conn.conv == conv {
+		server.mutex.Unlock()
+		return conn
+	}
+	if packet[4] != arqCmdPush || server.closing.Get() == 1 {
+		server.mutex.Unlock()
+		return nil
+	}
+	old := conn
+	conn = newUDPConn(pconn, addr, conv, server.hub, server.length, server.opts, server.remove)
+	if conn != nil {
+		server.sessions[key] = conn
+	}
+	server.mutex.Unlock()
+	//同一地址使用新conv，对方已重启
+	if old != nil {
+		old.close()
+	}
+	if conn == nil {
+		return nil
+	}
+	if reason, ok := server.hub.admit(conn.Ip()); !ok {
+		go conn.reject(reason)
+		return conn
+	}
+	conn.start()
+	return conn
+}
+
+// remove 连接关闭后移除
+func (server *UDPServer) remove(conn *UDPConn) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	key := conn.addr.String()
+	if server.sessions[key] == conn {
+		delete(server.sessions, key)
+	}
+}
+
+// Close 关闭socket，连接共享socket，同时全部断开
+func (server *UDPServer) Close() {
+	server.closing.Set(1)
+	server.mutex.Lock()
+	pconn := server.pconn
+	conns := make([]*UDPConn, 0, len(server.sessions))
+	for _, conn := range server.sessions {
+		conns = append(conns, conn)
+	}
+	server.mutex.Unlock()
+	if pconn != nil {
+		_ = pconn.Close()
+	}
+	for _, conn := range conns {
+		conn.close()
+	}
+}
+
+// Shutdown 优雅关闭：通知所有连接并等待已发送的数据被确认，连接全部断开或ctx超时后关闭socket
+func (server *UDPServer) Shutdown(ctx context.Context) error {
+	server.closing.Set(1)
+	err := server.hub.shutdown(ctx)
+	server.Close()
+	return err
+}
+
+// Registry 存活连接管理
+func (server *UDPServer) Registry() *Registry {
+	return server.hub.registry
+}
+
+// BackpressureStats 所有连接写入队列已满的统计
+func (server *UDPServer) BackpressureStats() BackpressureStats {
+	return server.opts.backpressure.snapshot()
+}
+
+// TrafficStats 所有连接的流量汇总，包括已断开的连接
+func (server *UDPServer) TrafficStats() TrafficStats {
+	return server.opts.traffic.snapshot()
+}
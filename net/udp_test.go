@@ -0,0 +1,164 @@
+package net
+
+import (
+	"fmt"
+	"github.com/yhhaiua/engine/buffer"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lossyConn 随机丢弃和重复发送的udp包
+type lossyConn struct {
+	net.PacketConn
+	mutex sync.Mutex
+	rand  *rand.Rand
+	loss  float64
+}
+
+func newLossyConn(t *testing.T, loss float64, seed int64) *lossyConn {
+	pconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &lossyConn{PacketConn: pconn, rand: rand.New(rand.NewSource(seed)), loss: loss}
+}
+
+func (c *lossyConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.mutex.Lock()
+	drop := c.rand.Float64() < c.loss
+	dup := c.rand.Float64() < c.loss/4
+	c.mutex.Unlock()
+	if drop {
+		return len(b), nil
+	}
+	if dup {
+		_, _ = c.PacketConn.WriteTo(b, addr)
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+func startUDP(t *testing.T, loss float64, l SocketListener, cl SocketListener, opts ...Option) (*UDPServer, *UDPClient) {
+	serverConn := newLossyConn(t, loss, 1)
+	server := NewUDPServer(serverConn.LocalAddr().String(), l, opts...)
+	go server.Serve(serverConn)
+	client := NewUDPClient(serverConn.LocalAddr().String(), cl, opts...)
+	client.listenPacket = func() (net.PacketConn, error) {
+		return newLossyConn(t, loss, 2), nil
+	}
+	if err := client.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return server, client
+}
+
+func TestUDPReliableOverLoss(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		config UDPConfig
+		loss   float64
+	}{{"normal", UDPNormal(), 0.05}, {"fast", UDPFast(), 0.2}} {
+		t.Run(c.name, func(t *testing.T) {
+			const count = 300
+			l := &testListener{}
+			l.onData = func(conn Channel, msg *buffer.ByteBuf) {
+				conn.WriteAndFlush(frame(msg.Bytes()))
+			}
+			cl := &testListener{}
+			server, client := startUDP(t, c.loss, l, cl, WithUDP(c.config))
+			defer server.Close()
+			defer client.Stop()
+
+			//包含需要分片的大消息
+			big := make([]byte, 5000)
+			for i := range big {
+				big[i] = byte(i)
+			}
+			conn := client.UdpConn()
+			for i := 0; i < count; i++ {
+				if i%50 == 0 {
+					conn.WriteAndFlush(frame(big))
+				} else {
+					conn.WriteAndFlush(frame([]byte(fmt.Sprintf("msg-%d", i))))
+				}
+			}
+			for start := time.Now(); cl.dataCount() < count; time.Sleep(10 * time.Millisecond) {
+				if time.Since(start) > 10*time.Second {
+					t.Fatalf("received %d/%d", cl.dataCount(), count)
+				}
+			}
+			for i := 0; i < count; i++ {
+				want := fmt.Sprintf("msg-%d", i)
+				if i%50 == 0 {
+					want = string(big)
+				}
+				if string(cl.data[i]) != want {
+					t.Fatalf("message %d out of order", i)
+				}
+			}
+			waitFor(t, func() bool { return conn.ArqStats().Sent > 0 })
+			if stats := conn.ArqStats(); stats.Retransmits+stats.FastResends == 0 {
+				t.Fatalf("no retransmit under loss: %+v", stats)
+			}
+		})
+	}
+}
+
+func TestUDPClose(t *testing.T) {
+	l := &testListener{}
+	cl := &testListener{}
+	server, client := startUDP(t, 0, l, cl)
+	defer server.Close()
+
+	client.UdpConn().WriteAndFlush(frame([]byte("bye")))
+	waitFor(t, func() bool { return server.Registry().Count() == 1 && l.dataCount() == 1 })
+	//关闭前发送完数据并通知服务器
+	client.Stop()
+	waitFor(t, func() bool { return l.disconnectedCount() == 1 && cl.disconnectedCount() == 1 })
+	if server.Registry().Count() != 0 {
+		t.Fatal("session not removed")
+	}
+}
+
+func TestUDPTimeout(t *testing.T) {
+	l := &testListener{}
+	cl := &testListener{}
+	server, client := startUDP(t, 0, l, cl, WithUDP(UDPConfig{Timeout: 300 * time.Millisecond}))
+	defer client.Stop()
+	client.UdpConn().WriteAndFlush(frame([]byte("hello")))
+	waitFor(t, func() bool { return l.dataCount() == 1 })
+
+	//保活期间不会超时
+	time.Sleep(500 * time.Millisecond)
+	if l.disconnectedCount() != 0 || cl.disconnectedCount() != 0 {
+		t.Fatal("timeout while alive")
+	}
+	server.Close()
+	waitFor(t, func() bool { return cl.disconnectedCount() == 1 })
+	if reason := cl.connected[0].(*UDPConn).DisconnectReason(); reason != DisconnectIdle {
+		t.Fatalf("reason %v", reason)
+	}
+}
+
+func TestUDPMessageTooLarge(t *testing.T) {
+	l := &testListener{}
+	cl := &testListener{}
+	server, client := startUDP(t, 0, l, cl)
+	defer server.Close()
+	defer client.Stop()
+
+	max := UDPConfig{}.MaxMessage()
+	if max != udpDefaultWindow*(udpDefaultMTU-arqOverhead) {
+		t.Fatalf("max message %d", max)
+	}
+	//超过最大长度的消息丢弃，连接不断开
+	conn := client.UdpConn()
+	conn.WriteAndFlush(frame(make([]byte, max)))
+	conn.WriteAndFlush(frame([]byte("small")))
+	waitFor(t, func() bool { return l.dataCount() == 1 })
+	if string(l.data[0]) != "small" || cl.disconnectedCount() != 0 || l.disconnectedCount() != 0 {
+		t.Fatalf("data %q disconnected %d/%d", l.data[0], cl.disconnectedCount(), l.disconnectedCount())
+	}
+}
@@ -0,0 +1,43 @@
+package net
+
+import (
+	"github.com/yhhaiua/engine/util"
+)
+
+// defaultMaxBatchBytes 单次批量写入的默认最大字节数
+const defaultMaxBatchBytes = 64 * 1024
+
+// WriteStats 批量写入统计，Messages/Writes为平均每次系统调用写入的消息数
+type WriteStats struct {
+	Writes   int64 //写入次数（系统调用）
+	Messages int64 //写入消息数
+	Bytes    int64 //写入字节数
+}
+
+// Ratio 平均每次写入的消息数
+func (s WriteStats) Ratio() float64 {
+	if s.Writes == 0 {
+		return 0
+	}
+	return float64(s.Messages) / float64(s.Writes)
+}
+
+type writeCounter struct {
+	writes   util.AtomicLong
+	messages util.AtomicLong
+	bytes    util.AtomicLong
+}
+
+func (c *writeCounter) add(messages, bytes int) {
+	c.writes.IncrementAndGet()
+	c.messages.AddAndGet(int64(messages))
+	c.bytes.AddAndGet(int64(bytes))
+}
+
+func (c *writeCounter) snapshot() WriteStats {
+	return WriteStats{
+		Writes:   c.writes.Get(),
+		Messages: c.messages.Get(),
+		Bytes:    c.bytes.Get(),
+	}
+}
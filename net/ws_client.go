@@ -1,15 +1,56 @@
 package net
 
 import (
+	"context"
+	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/yhhaiua/engine/buffer"
-	"strings"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
 )
 
+// WsDialer websocket客户端拨号配置，TLS使用Options.TLSConfig
+type WsDialer struct {
+	Header            http.Header                           //握手请求头，如鉴权token
+	Jar               http.CookieJar                        //握手请求携带的cookie
+	Subprotocols      []string                              //请求的子协议，协商结果见WSConn.Subprotocol
+	HandshakeTimeout  time.Duration                         //握手超时，0使用默认45s
+	EnableCompression bool                                  //请求permessage-deflate压缩
+	Proxy             func(*http.Request) (*url.URL, error) //代理，为空时使用环境变量HTTP_PROXY等
+}
+
+// WsDialError websocket连接失败
+type WsDialError struct {
+	Addr       string
+	StatusCode int //握手时服务器返回的http状态码，未收到响应时为0
+	Err        error
+}
+
+func (e *WsDialError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("ws dial %s: %v (status %d)", e.Addr, e.Err, e.StatusCode)
+	}
+	return fmt.Sprintf("ws dial %s: %v", e.Addr, e.Err)
+}
+
+func (e *WsDialError) Unwrap() error {
+	return e.Err
+}
+
 type WsClient struct {
 	addr     string
 	listener SocketListener
 	wsConn   *WSConn
+	opts     *Options
+	mutex    sync.Mutex
+	chStop   chan struct{}
+	chLost   chan struct{}
+	ctx      context.Context //Stop时取消，中断正在进行的连接
+	cancel   context.CancelFunc
+	stopped  bool
 }
 
 func (w *WsClient) OnConnected(conn Channel) {
@@ -18,44 +59,189 @@ func (w *WsClient) OnConnected(conn Channel) {
 
 func (w *WsClient) OnDisconnected(conn Channel) {
 	w.listener.OnDisconnected(conn)
-	w.wsConn = nil
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.wsConn == conn {
+		w.wsConn = nil
+		close(w.chLost)
+		w.chLost = nil
+	}
 }
 
 func (w *WsClient) OnData(conn Channel, msg *buffer.ByteBuf) {
 	w.listener.OnData(conn, msg)
 }
 
+func (w *WsClient) OnIdle(conn Channel, state IdleState) {
+	if l, ok := w.listener.(SocketIdleListener); ok {
+		l.OnIdle(conn, state)
+		return
+	}
+	defaultIdle(conn, state)
+}
+
+// WsConn 当前连接，未连接时为nil
 func (w *WsClient) WsConn() *WSConn {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
 	return w.wsConn
 }
 
-func NewWsClient(addr string, listener SocketListener) *WsClient {
+func NewWsClient(addr string, listener SocketListener, opts ...Option) *WsClient {
+	ctx, cancel := context.WithCancel(context.Background())
 	server := &WsClient{
 		addr:     addr,
 		listener: listener,
+		opts:     newOptions(opts...),
+		ctx:      ctx,
+		cancel:   cancel,
 	}
 	return server
 }
 
-func (w *WsClient) Start() {
-	conn := w.dial()
-	if conn != nil {
-		logger.Infof("WsClient connect success:%s", w.addr)
-		str := conn.RemoteAddr().String()
-		info := strings.Split(str, ":")
-		w.wsConn = newWSConn(conn, w, info[0])
-		if w.wsConn != nil {
-			w.wsConn.start()
+// Start 立即连接一次并返回连接错误（*WsDialError），之后在后台断线重连（首次失败时同样按退避重连），直到Stop
+func (w *WsClient) Start() error {
+	w.mutex.Lock()
+	if w.chStop != nil || w.stopped {
+		w.mutex.Unlock()
+		return nil
+	}
+	chStop := make(chan struct{})
+	w.chStop = chStop
+	w.mutex.Unlock()
+
+	conn, chLost, err := w.connect()
+	go func() {
+		if err == nil {
+			if w.serve(conn, chLost, chStop) {
+				return
+			}
 		}
+		w.opts.Reconnect.loop(w.addr, chStop, err, err == nil, w.connect, func(conn Channel, chLost chan struct{}) bool {
+			return w.serve(conn, chLost, chStop)
+		})
+	}()
+	return err
+}
+
+// Connect 立即连接一次并返回连接错误（*WsDialError），断开后不重连，Stop关闭连接
+func (w *WsClient) Connect() error {
+	w.mutex.Lock()
+	if w.wsConn != nil || w.stopped {
+		w.mutex.Unlock()
+		return nil
 	}
+	w.mutex.Unlock()
+	_, _, err := w.connect()
+	return err
 }
 
-func (w *WsClient) dial() *websocket.Conn {
+// serve 等待连接断开，返回true表示已停止
+func (w *WsClient) serve(conn Channel, chLost, chStop chan struct{}) bool {
+	select {
+	case <-chStop:
+		conn.Close()
+		return true
+	case <-chLost:
+		return false
+	}
+}
 
-	c, _, err := websocket.DefaultDialer.Dial(w.addr, nil)
+// connect 连接一次，返回连接及断开通知
+func (w *WsClient) connect() (Channel, chan struct{}, error) {
+	conn, err := w.dial()
+	if err != nil {
+		return nil, nil, err
+	}
+	logger.Infof("WsClient connect success:%s", w.addr)
+	var channel *secureChannel
+	if w.opts.Secure != nil {
+		if channel, err = secureHandshake(wsTransport{conn: conn, limit: w.opts.WsUpgrader.readLimit()}, w.opts.Secure, false); err != nil {
+			logger.Errorf("WsClient secure handshake err: %s", err.Error())
+			_ = conn.Close()
+			return nil, nil, err
+		}
+	}
+	wsConn := newWSConn(conn, w, addrIp(conn.RemoteAddr()), w.opts)
+	if wsConn == nil {
+		_ = conn.Close()
+		return nil, nil, errCreateConn
+	}
+	wsConn.secure = channel
+	chLost := make(chan struct{})
+	w.mutex.Lock()
+	if w.stopped {
+		//连接期间已Stop，不再使用该连接
+		w.mutex.Unlock()
+		_ = conn.Close()
+		return nil, nil, context.Canceled
+	}
+	w.wsConn = wsConn
+	w.chLost = chLost
+	w.mutex.Unlock()
+	wsConn.start()
+	return wsConn, chLost, nil
+}
+
+func (w *WsClient) dial() (*websocket.Conn, error) {
+
+	config := &w.opts.WsDialer
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = w.opts.TLSConfig
+	dialer.Jar = config.Jar
+	dialer.Subprotocols = config.Subprotocols
+	dialer.EnableCompression = config.EnableCompression
+	if config.HandshakeTimeout > 0 {
+		dialer.HandshakeTimeout = config.HandshakeTimeout
+	}
+	if config.Proxy != nil {
+		dialer.Proxy = config.Proxy
+	}
+	//握手读取不受ctx控制，Stop时关闭底层连接中断握手
+	var stopWatch func() bool
+	dialer.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+		if err == nil {
+			stopWatch = context.AfterFunc(w.ctx, func() { _ = conn.Close() })
+		}
+		return conn, err
+	}
+	c, resp, err := dialer.DialContext(w.ctx, w.addr, config.Header)
+	if stopWatch != nil {
+		stopWatch()
+	}
 	if err == nil {
-		return c
+		return c, nil
+	}
+	dialErr := &WsDialError{Addr: w.addr, Err: err}
+	if resp != nil {
+		dialErr.StatusCode = resp.StatusCode
+		_ = resp.Body.Close()
 	}
-	logger.Errorf("WsClient connect err: %s", err.Error())
-	return nil
+	logger.Errorf("WsClient connect err: %s", dialErr.Error())
+	return nil, dialErr
+}
+
+// Stop 停止重连并关闭当前连接
+func (w *WsClient) Stop() {
+	w.mutex.Lock()
+	if w.stopped {
+		w.mutex.Unlock()
+		return
+	}
+	w.stopped = true
+	chStop := w.chStop
+	wsConn := w.wsConn
+	w.mutex.Unlock()
+	w.cancel()
+	if chStop != nil {
+		close(chStop)
+	} else if wsConn != nil {
+		wsConn.Close()
+	}
+}
+
+// Close 同Stop
+func (w *WsClient) Close() {
+	w.Stop()
 }
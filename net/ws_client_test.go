@@ -0,0 +1,194 @@
+package net
+
+import (
+	"errors"
+	"github.com/gorilla/websocket"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWsClientDialer(t *testing.T) {
+	upgrader := websocket.Upgrader{Subprotocols: []string{"v2"}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err == nil {
+			defer conn.Close()
+			_, _, _ = conn.ReadMessage()
+		}
+	}))
+	defer server.Close()
+	addr := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	//鉴权失败返回状态码
+	client := NewWsClient(addr, &testListener{})
+	err := client.Start()
+	client.Stop()
+	var dialErr *WsDialError
+	if !errors.As(err, &dialErr) || dialErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("err %v", err)
+	}
+
+	cl := &testListener{}
+	client = NewWsClient(addr, cl, WithWsDialer(WsDialer{
+		Header:           http.Header{"Authorization": {"token"}},
+		Subprotocols:     []string{"v1", "v2"},
+		HandshakeTimeout: time.Second,
+	}))
+	if err := client.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop()
+	if client.WsConn() == nil || client.WsConn().Subprotocol() != "v2" {
+		t.Fatal("subprotocol not negotiated")
+	}
+}
+
+func TestWsClientReconnect(t *testing.T) {
+	l := &testListener{}
+	addr := freeAddr(t)
+	server := NewWSServer(addr, l)
+	go server.Listen()
+	defer server.Close()
+	dialRetry(t, addr).Close()
+
+	reconnected := make(chan Channel, 1)
+	cl := &testListener{}
+	client := NewWsClient("ws://"+addr+"/", cl, WithReconnect(Reconnect{
+		Initial: 20 * time.Millisecond,
+		OnReconnected: func(conn Channel) {
+			reconnected <- conn
+		},
+	}))
+	if err := client.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return server.Registry().Count() == 1 })
+	server.Registry().Range(func(conn Channel) bool {
+		server.Registry().Kick(conn.Id(), nil)
+		return true
+	})
+	select {
+	case conn := <-reconnected:
+		if conn != client.WsConn() {
+			t.Fatal("current conn not updated")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("not reconnected")
+	}
+
+	client.Stop()
+	waitFor(t, func() bool { return cl.disconnectedCount() == 2 })
+	if cl.connectedCount() != 2 {
+		t.Fatalf("connected %d", cl.connectedCount())
+	}
+}
+
+func TestWsClientGiveUp(t *testing.T) {
+	var mutex sync.Mutex
+	dials := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		dials++
+		mutex.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	addr := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	//MaxAttempts为连接总次数，包括Start中的首次连接
+	for _, attempts := range []int{1, 3} {
+		mutex.Lock()
+		dials = 0
+		mutex.Unlock()
+		giveUp := make(chan error, 1)
+		client := NewWsClient(addr, &testListener{}, WithReconnect(Reconnect{
+			Initial:     time.Millisecond,
+			MaxAttempts: attempts,
+			OnGiveUp: func(err error) {
+				giveUp <- err
+			},
+		}))
+		if err := client.Start(); err == nil {
+			t.Fatal("start succeeded")
+		}
+		select {
+		case <-giveUp:
+		case <-time.After(2 * time.Second):
+			t.Fatal("client did not give up")
+		}
+		client.Stop()
+		mutex.Lock()
+		if dials != attempts {
+			t.Fatalf("max attempts %d dialed %d", attempts, dials)
+		}
+		mutex.Unlock()
+	}
+}
+
+func TestWsClientIPv6(t *testing.T) {
+	ln, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skip("ipv6 not available")
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+	server := NewWSServer(addr, &testListener{})
+	go server.Listen()
+	defer server.Close()
+	dialRetry(t, addr).Close()
+
+	client := NewWsClient("ws://"+addr+"/", &testListener{})
+	if err := client.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop()
+	if ip := client.WsConn().Ip(); ip != "::1" {
+		t.Fatalf("ip %q", ip)
+	}
+}
+
+func TestWsClientStopDuringDial(t *testing.T) {
+	//只接受连接不回复，握手一直等待
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	client := NewWsClient("ws://"+ln.Addr().String()+"/", &testListener{})
+	started := make(chan error, 1)
+	go func() {
+		started <- client.Start()
+	}()
+	time.Sleep(50 * time.Millisecond)
+	stop := time.Now()
+	client.Stop()
+	select {
+	case err := <-started:
+		if err == nil {
+			t.Fatal("start succeeded")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stop did not cancel dial")
+	}
+	if elapsed := time.Since(stop); elapsed > 500*time.Millisecond || client.WsConn() != nil {
+		t.Fatalf("stop took %v", elapsed)
+	}
+}
@@ -25,13 +25,19 @@ type WSConn struct {
 	receive       *buffer.ByteBuf
 	listener      SocketListener
 	hd            handler.Handler
-	chData        chan []byte
+	encoder       handler.Encoder
+	queue         *writeQueue
 	chStopWrite   chan struct{}
-	connected     bool
 	connectAtomic util.AtomicInteger
 	ip            string
-	closeData     bool
+	stopWrite     bool
 	id            int64
+	opts          *Options
+	mailbox       *mailbox
+	limiter       *rateLimiter
+	secure        *secureChannel
+	attrs         Attrs
+	metrics       connMetrics
 }
 
 func (t *WSConn) Id() int64 {
@@ -41,26 +47,54 @@ func (t *WSConn) Ip() string {
 	return t.ip
 }
 
-func newWSConn(conn *websocket.Conn, listener SocketListener, ip string) *WSConn {
+// Attrs 连接属性
+func (t *WSConn) Attrs() *Attrs {
+	return &t.attrs
+}
+
+// Encoder 连接使用的消息编码器
+func (t *WSConn) Encoder() handler.Encoder {
+	return t.encoder
+}
+
+// Subprotocol 握手协商的子协议
+func (t *WSConn) Subprotocol() string {
+	return t.conn.Subprotocol()
+}
+
+func newWSConn(conn *websocket.Conn, listener SocketListener, ip string, opts *Options) *WSConn {
 	t := new(WSConn)
 	t.conn = conn
+	t.opts = opts
 	t.listener = listener
 	t.receive = buffer.NewByteBuf()
-	t.chData = make(chan []byte, WsDataLength)
+	t.queue = newWriteQueue(WsDataLength, opts, &t.metrics)
 	t.chStopWrite = make(chan struct{})
-	t.connected = true
-	hd, err := handler.NewWsDecoder()
+	var hd handler.Handler
+	var err error
+	if opts.NewHandler != nil {
+		hd, err = opts.NewHandler()
+	} else {
+		hd, err = handler.NewWsDecoder()
+	}
 	if err != nil {
 		logger.Errorf("new WSConn err: %v", err)
 		return nil
 	}
 	t.hd = hd
+	t.encoder = opts.encoder()
+	t.limiter = newRateLimiter(opts.RateLimit)
+	if opts.Serial {
+		t.mailbox = newMailbox(opts.MailboxLimit)
+	}
 	t.ip = ip
 	t.id = globalId.IncrementAndGet()
+	t.metrics.init(opts)
 	return t
 }
 func (t *WSConn) start() {
-
+	t.metrics.start()
+	t.opts.Capture.record(t, CaptureConnect, nil)
 	t.listener.OnConnected(t)
 	go t.read()
 	go t.run()
@@ -72,27 +106,47 @@ func (t *WSConn) read() {
 		if r := recover(); r != nil {
 			logger.TraceErr(r)
 			t.close()
-			t.listener.OnDisconnected(t)
+			dispatchDisconnected(t.mailbox, t.listener, t)
 		}
 	}()
 	//一次从管管中读取的最大长度
-	t.conn.SetReadLimit(maxMsgSize)
-	//连接中，每隔54秒向客户端发一次ping，客户端返回pong，所以把SetReadDeadline设为60秒，超过60秒后不允许读
-	_ = t.conn.SetReadDeadline(time.Now().Add(pongWait))
+	t.conn.SetReadLimit(t.opts.WsUpgrader.readLimit())
+	//连接中，每隔PingPeriod向客户端发一次ping，客户端返回pong，所以把SetReadDeadline设为PongWait，超过后不允许读
+	_ = t.conn.SetReadDeadline(time.Now().Add(t.opts.PongWait))
 	//心跳
 	t.conn.SetPongHandler(func(appData string) error {
-		//每次收到pong都把deadline往后推迟60秒
-		_ = t.conn.SetReadDeadline(time.Now().Add(pongWait))
+		//每次收到pong都把deadline往后推迟PongWait
+		_ = t.conn.SetReadDeadline(time.Now().Add(t.opts.PongWait))
 		return nil
 	})
 	for {
 		_, b, err := t.conn.ReadMessage()
+		if err != nil && t.connectAtomic.Get() != 0 {
+			//本端已断开，原因已记录
+			dispatchDisconnected(t.mailbox, t.listener, t)
+			return
+		}
 		if err != nil {
 			logger.Warnf("远程连接：%s,关闭 %s", t.Ip(), err.Error())
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				t.metrics.disconnect(DisconnectRemote)
+			} else {
+				t.metrics.disconnect(DisconnectReadError)
+			}
 			t.close()
-			t.listener.OnDisconnected(t)
+			dispatchDisconnected(t.mailbox, t.listener, t)
 			return
 		}
+		t.metrics.read(len(b))
+		if t.secure != nil {
+			if b, err = t.secure.recv.open(b); err != nil {
+				logger.Errorf("远程连接：%s,关闭 %s", t.Ip(), err.Error())
+				t.metrics.disconnect(DisconnectDecodeError)
+				t.close()
+				dispatchDisconnected(t.mailbox, t.listener, t)
+				return
+			}
+		}
 		length := len(b)
 		if !t.hd.IsValidLength(length) {
 			logger.Errorf("msg err,too large: %d,Ip:%s", length, t.Ip())
@@ -106,18 +160,40 @@ func (t *WSConn) read() {
 		msg, err := t.hd.Decode(t.receive)
 		if err != nil {
 			logger.Errorf("msg err: %s,Ip:%s", err.Error(), t.Ip())
+			t.metrics.decodeError()
+			continue
+		}
+		if msg == nil {
 			continue
 		}
-		if msg != nil {
-			t.listener.OnData(t, msg)
+		t.metrics.frame(msg)
+		t.opts.Capture.record(t, CaptureInbound, msg.Bytes())
+		pass, closed := t.limiter.check(t, msg.ReadableBytes())
+		if closed {
+			t.metrics.disconnect(DisconnectRateLimit)
+			t.close()
+			dispatchDisconnected(t.mailbox, t.listener, t)
+			return
+		}
+		if pass && !dispatchData(t.mailbox, t.listener, t, msg) {
+			t.metrics.disconnect(DisconnectMailboxFull)
+			t.close()
+			dispatchDisconnected(t.mailbox, t.listener, t)
+			return
 		}
 	}
 }
 
+// extendReadDeadline 限速等待期间无法读取pong，等待后重新计算pong超时
+func (t *WSConn) extendReadDeadline() {
+	_ = t.conn.SetReadDeadline(time.Now().Add(t.opts.PongWait))
+}
+
 func (t *WSConn) run() {
 	//给前端发心跳，看前端是否还存活
-	ticker := time.NewTicker(pingPeriod)
+	ticker := time.NewTicker(t.opts.PingPeriod)
 	defer func() {
+		close(t.queue.chDone)
 		ticker.Stop()
 		if r := recover(); r != nil {
 			logger.TraceErr(r)
@@ -127,21 +203,29 @@ func (t *WSConn) run() {
 
 	for {
 		select {
-		case msg, ok := <-t.chData:
+		case msg, ok := <-t.queue.chData:
 			if !ok || msg == nil {
 				logger.Infof("msg close:%s", t.Ip())
 				t.close()
 				return
 			}
-			if msg != nil && t.connected {
+			if msg != nil && t.connectAtomic.Get() == 0 {
 				//10秒内必须把信息写给前端（写到websocket连接里去），否则就关闭连接
 				_ = t.conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if t.secure != nil {
+					msg = t.secure.send.seal(nil, msg)
+				}
+				if threshold := t.opts.WsUpgrader.CompressThreshold; threshold > 0 {
+					t.conn.EnableWriteCompression(len(msg) >= threshold)
+				}
 				err := t.conn.WriteMessage(websocket.BinaryMessage, msg)
 				if err != nil {
 					logger.Errorf(" WriteMessage:%s", err.Error())
+					t.metrics.disconnect(DisconnectWriteError)
 					t.close()
 					return
 				}
+				t.metrics.write(1, len(msg))
 			}
 		case <-t.chStopWrite:
 			logger.Infof("chStopWrite destroy:%s", t.Ip())
@@ -151,6 +235,7 @@ func (t *WSConn) run() {
 			//心跳保持，给浏览器发一个PingMessage，等待浏览器返回PongMessage
 			if err := t.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				logger.Errorf(" websocket.PingMessage:%s", err.Error())
+				t.metrics.disconnect(DisconnectWriteError)
 				t.close()
 				return //写websocket连接失败，说明连接出问题了，该client可以over了
 			}
@@ -159,6 +244,17 @@ func (t *WSConn) run() {
 
 }
 
+// reject 拒绝连接，只启动写协程，通知后发送完队列中的数据再关闭
+func (t *WSConn) reject(reason RejectReason) {
+	t.metrics.reject()
+	logger.Infof("reject conn:%s,reason:%d", t.Ip(), reason)
+	go t.run()
+	if t.opts.OnReject != nil {
+		t.opts.OnReject(t, reason)
+	}
+	t.Close()
+}
+
 // Destroy 目标通知断开后销毁
 func (t *WSConn) Destroy() {
 	t.doDestroy()
@@ -166,21 +262,19 @@ func (t *WSConn) Destroy() {
 
 // WriteAndFlush 向目标发送数据
 func (t *WSConn) WriteAndFlush(msg []byte) {
-	if t.closeData {
+	if t.queue.closed() {
 		return
 	}
+	t.opts.Capture.record(t, CaptureOutbound, msg)
 	t.doWrite(msg)
 }
 
 // Close 主动关闭连接（调用前先向目标发送关闭信息）
 func (t *WSConn) Close() {
-	t.closeMutex.Lock()
-	defer t.closeMutex.Unlock()
-	if t.closeData {
+	if !t.queue.closeFlag.CompareAndSet(0, 1) {
 		return
 	}
-	t.chData <- nil
-	t.closeData = true
+	t.doWrite(nil)
 }
 
 func (t *WSConn) close() {
@@ -188,38 +282,48 @@ func (t *WSConn) close() {
 	if !t.connectAtomic.CompareAndSet(0, 1) {
 		return
 	}
-	if !t.connected {
-		return
-	}
-	t.connected = false
+	t.metrics.closed()
+	t.opts.Capture.record(t, CaptureDisconnect, nil)
 	_ = t.conn.Close()
 }
 
+// closeWith 记录断开原因后断开
+func (t *WSConn) closeWith(reason DisconnectReason) {
+	t.metrics.disconnect(reason)
+	t.close()
+}
+
 func (t *WSConn) doDestroy() {
 	t.close()
+	t.queue.closeFlag.Set(1)
 	t.closeMutex.Lock()
 	defer t.closeMutex.Unlock()
-	if t.closeData {
+	if t.stopWrite {
 		return
 	}
 	close(t.chStopWrite)
-	t.closeData = true
+	t.stopWrite = true
 }
 
+// doWrite 写入队列，队列已满时按配置的策略处理
 func (t *WSConn) doWrite(msg []byte) {
-	select {
-	case t.chData <- msg:
-	default:
-		t.full(msg)
+	if t.queue.push(t, msg) {
+		t.metrics.disconnect(DisconnectBackpressure)
+		t.close()
 	}
 }
 
-func (t *WSConn) full(msg []byte) {
-	logger.Infof("队列已满进行等待,ip:%s,len:%d", t.Ip(), len(t.chData))
-	t.closeMutex.Lock()
-	defer t.closeMutex.Unlock()
-	if t.closeData {
-		return
-	}
-	t.chData <- msg
+// BackpressureStats 连接写入队列已满的统计
+func (t *WSConn) BackpressureStats() BackpressureStats {
+	return t.queue.counter.snapshot()
+}
+
+// Stats 连接的流量统计
+func (t *WSConn) Stats() ConnStats {
+	return t.metrics.snapshot(t.id, t.Ip(), t.queue)
+}
+
+// DisconnectReason 断开原因，未断开时为0
+func (t *WSConn) DisconnectReason() DisconnectReason {
+	return DisconnectReason(t.metrics.reason.Get())
 }
@@ -1,66 +1,289 @@
 package net
 
 import (
+	"context"
+	"errors"
 	"github.com/gorilla/websocket"
+	"github.com/yhhaiua/engine/grouter"
 	"github.com/yhhaiua/engine/util"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+// WsUpgrader websocket服务器握手配置，零值字段使用默认值
+type WsUpgrader struct {
+	ReadLimit         int64                              //单条消息最大长度，默认65534，客户端连接同样生效，开启压缩时为压缩后的长度
+	AllowedOrigins    []string                           //允许的Origin，如https://game.example.com、game.example.com、*.example.com，为空不检查
+	CheckOrigin       func(r *http.Request) bool         //自定义Origin检查，设置后忽略AllowedOrigins
+	Subprotocols      []string                           //支持的子协议，按顺序选择客户端请求的第一个，结果见WSConn.Subprotocol
+	EnableCompression bool                               //协商permessage-deflate压缩
+	CompressionLevel  int                                //压缩级别（-2~9），0使用默认
+	CompressThreshold int                                //开启压缩时超过该字节数的消息才压缩，0全部压缩
+	HandshakeTimeout  time.Duration                      //握手超时，默认30s
+	Authorize         func(r *http.Request) (any, error) //握手前鉴权（token参数、请求头等），返回错误时拒绝，返回的数据在OnConnected前设置到WsAuthData
+}
+
+// WsRejectError Authorize拒绝连接时返回的http状态码和内容，其他错误返回401
+type WsRejectError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *WsRejectError) Error() string {
+	return "websocket reject: " + strconv.Itoa(e.StatusCode) + " " + e.Message
+}
+
+// WsAuthData WsUpgrader.Authorize返回的数据
+var WsAuthData = NewAttrKey[any]("ws.auth")
+
+// WsParams 挂载到路由时的路径参数（如/ws/:zone）
+var WsParams = NewAttrKey[grouter.Params]("ws.params")
+
+// readLimit 单条消息最大长度
+func (u *WsUpgrader) readLimit() int64 {
+	if u.ReadLimit > 0 {
+		return u.ReadLimit
+	}
+	return maxMsgSize
+}
+
+// checkOrigin 浏览器请求的Origin是否在允许列表中，非浏览器请求没有Origin
+func (u *WsUpgrader) checkOrigin(r *http.Request) bool {
+	if u.CheckOrigin != nil {
+		return u.CheckOrigin(r)
+	}
+	origin := r.Header.Get("Origin")
+	if len(u.AllowedOrigins) == 0 || origin == "" {
+		return true
+	}
+	o, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range u.AllowedOrigins {
+		switch {
+		case strings.Contains(allowed, "://"):
+			if strings.EqualFold(allowed, origin) {
+				return true
+			}
+		case strings.HasPrefix(allowed, "*."):
+			if strings.HasSuffix(strings.ToLower(o.Hostname()), strings.ToLower(allowed[1:])) {
+				return true
+			}
+		default:
+			if strings.EqualFold(allowed, o.Host) || strings.EqualFold(allowed, o.Hostname()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 type WSServer struct {
 	addr        string
 	listener    SocketListener
 	upGrader    websocket.Upgrader
 	HTTPTimeout time.Duration
 	server      *http.Server
+	hub         *serverListener
+	opts        *Options
+	mutex       sync.Mutex
 }
 
-func NewWSServer(addr string, listener SocketListener) *WSServer {
+func NewWSServer(addr string, listener SocketListener, opts ...Option) *WSServer {
+	o := newOptions(opts...)
+	config := &o.WsUpgrader
 	server := &WSServer{
 		addr:        addr,
 		listener:    listener,
 		HTTPTimeout: 30 * time.Second,
+		hub:         newServerListener(listener, o),
+		opts:        o,
 		upGrader: websocket.Upgrader{
-			HandshakeTimeout: 30 * time.Second,
-			CheckOrigin:      func(_ *http.Request) bool { return true },
+			HandshakeTimeout:  30 * time.Second,
+			CheckOrigin:       config.checkOrigin,
+			Subprotocols:      config.Subprotocols,
+			EnableCompression: config.EnableCompression,
 		},
 	}
+	if config.HandshakeTimeout > 0 {
+		server.upGrader.HandshakeTimeout = config.HandshakeTimeout
+	}
 	return server
 }
 
 func (ws *WSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws.serve(w, r, nil)
+}
 
+// Mount 挂载到路由的path（GET），多个websocket服务可使用不同的路径、监听和编解码共用一个http服务器的端口和TLS，
+// 挂载后不调用Listen，关闭时使用ShutdownHTTP
+func (ws *WSServer) Mount(router *grouter.Router, path string) {
+	router.GET(path, ws.serve)
+}
+
+func (ws *WSServer) serve(w http.ResponseWriter, r *http.Request, ps grouter.Params) {
 	if r.Method != "GET" {
 		http.Error(w, "Method not allowed", 405)
 		return
 	}
 
+	config := &ws.opts.WsUpgrader
+	var data any
+	if config.Authorize != nil {
+		var err error
+		if data, err = config.Authorize(r); err != nil {
+			status, message := http.StatusUnauthorized, ""
+			var reject *WsRejectError
+			if errors.As(err, &reject) {
+				status, message = reject.StatusCode, reject.Message
+			}
+			if message == "" {
+				message = http.StatusText(status)
+			}
+			logger.Debugf("websocket reject: %s,ip:%s", err.Error(), util.GetUserIp(r))
+			http.Error(w, message, status)
+			return
+		}
+	}
 	c, err := ws.upGrader.Upgrade(w, r, nil)
 	if err != nil {
 		logger.Debugf("upgrade: %s,ip:%s", err.Error(), util.GetUserIp(r))
 		return
 	}
-	c.SetReadLimit(32767)
-	wsConn := newWSConn(c, ws.listener, util.GetUserIp(r))
-	if wsConn != nil {
-		wsConn.start()
+	c.SetReadLimit(config.readLimit())
+	if config.CompressionLevel != 0 {
+		_ = c.SetCompressionLevel(config.CompressionLevel)
+	}
+	ip := util.GetUserIp(r)
+	if reason, ok := ws.hub.admit(ip); !ok {
+		ws.reject(c, ip, reason)
+		return
+	}
+	var channel *secureChannel
+	if ws.opts.Secure != nil {
+		if channel, err = secureHandshake(wsTransport{conn: c, limit: config.readLimit()}, ws.opts.Secure, true); err != nil {
+			logger.Errorf("secure handshake close:%s,err:%s", ip, err.Error())
+			_ = c.Close()
+			ws.hub.release(ip)
+			return
+		}
+	}
+	wsConn := newWSConn(c, ws.hub, ip, ws.opts)
+	if wsConn == nil {
+		_ = c.Close()
+		ws.hub.release(ip)
+		return
+	}
+	wsConn.secure = channel
+	if data != nil {
+		WsAuthData.Set(wsConn, data)
+	}
+	if len(ps) > 0 {
+		WsParams.Set(wsConn, ps)
+	}
+	wsConn.start()
+}
+
+// reject 超过连接数限制，回调OnReject后断开，开启加密时未握手无法发送消息，直接断开
+func (ws *WSServer) reject(c *websocket.Conn, ip string, reason RejectReason) {
+	if ws.opts.Secure != nil {
+		logger.Infof("reject conn:%s,reason:%d", ip, reason)
+		_ = c.Close()
+		return
+	}
+	wsConn := newWSConn(c, ws.hub, ip, ws.opts)
+	if wsConn == nil {
+		_ = c.Close()
+		return
 	}
+	wsConn.reject(reason)
 }
+
 func (ws *WSServer) Listen() {
-	ws.server = &http.Server{
+	server := &http.Server{
 		ReadTimeout:  ws.HTTPTimeout,
 		WriteTimeout: ws.HTTPTimeout,
 		Addr:         ws.addr,
 		Handler:      ws,
+		TLSConfig:    ws.opts.TLSConfig,
 	}
+	ws.mutex.Lock()
+	ws.server = server
+	ws.mutex.Unlock()
 	logger.Infof("websocket start monitor %s", ws.addr)
-	err := ws.server.ListenAndServe()
+	var err error
+	if ws.opts.TLSConfig != nil {
+		//证书已在TLSConfig中配置
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		logger.Infof("websocket server closed:%s", ws.addr)
+		return
+	}
 	if err != nil {
 		logger.Errorf("websocket monitor fail %s", err.Error())
 	}
 }
 func (ws *WSServer) Close() {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
 	if ws.server != nil {
 		_ = ws.server.Close()
 	}
 }
+
+// Shutdown 优雅关闭：停止接收新连接，通知所有连接并发送完队列中的数据，等待连接全部断开或ctx超时。
+// http服务器关闭出错时同样关闭websocket连接，返回第一个错误
+func (ws *WSServer) Shutdown(ctx context.Context) error {
+	ws.mutex.Lock()
+	server := ws.server
+	ws.mutex.Unlock()
+	var err error
+	if server != nil {
+		err = server.Shutdown(ctx)
+	}
+	if e := ws.hub.shutdown(ctx); err == nil {
+		err = e
+	}
+	return err
+}
+
+// ShutdownHTTP 优雅关闭挂载了websocket服务的http服务器：先关闭http服务器（停止接收新请求，等待http请求完成），
+// 再同时关闭所有websocket服务（升级后的连接不受http服务器管理），返回第一个错误
+func ShutdownHTTP(ctx context.Context, server *http.Server, servers ...*WSServer) error {
+	err := server.Shutdown(ctx)
+	errs := make(chan error, len(servers))
+	for _, ws := range servers {
+		go func(ws *WSServer) {
+			errs <- ws.Shutdown(ctx)
+		}(ws)
+	}
+	for range servers {
+		if e := <-errs; err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Registry 存活连接管理
+func (ws *WSServer) Registry() *Registry {
+	return ws.hub.registry
+}
+
+// BackpressureStats 所有连接写入队列已满的统计
+func (ws *WSServer) BackpressureStats() BackpressureStats {
+	return ws.opts.backpressure.snapshot()
+}
+
+// TrafficStats 所有连接的流量汇总，包括已断开的连接
+func (ws *WSServer) TrafficStats() TrafficStats {
+	return ws.opts.traffic.snapshot()
+}
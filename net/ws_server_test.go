@@ -0,0 +1,240 @@
+package net
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/yhhaiua/engine/buffer"
+	"github.com/yhhaiua/engine/grouter"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWSServerAuthorize(t *testing.T) {
+	var authed []any
+	l := &testListener{}
+	addr := freeAddr(t)
+	server := NewWSServer(addr, &authListener{testListener: l, authed: &authed}, WithWsUpgrader(WsUpgrader{
+		Authorize: func(r *http.Request) (any, error) {
+			switch r.URL.Query().Get("token") {
+			case "":
+				return nil, errors.New("no token")
+			case "banned":
+				return nil, &WsRejectError{StatusCode: http.StatusForbidden, Message: "banned"}
+			}
+			return "user-" + r.URL.Query().Get("token"), nil
+		},
+	}))
+	go server.Listen()
+	defer server.Close()
+	dialRetry(t, addr).Close()
+
+	for token, status := range map[string]int{"": http.StatusUnauthorized, "banned": http.StatusForbidden} {
+		_, resp, err := websocket.DefaultDialer.Dial("ws://"+addr+"/?token="+token, nil)
+		if err == nil || resp == nil || resp.StatusCode != status {
+			t.Fatalf("token %q resp %v err %v", token, resp, err)
+		}
+	}
+	c, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/?token=7", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	waitFor(t, func() bool { return l.connectedCount() == 1 })
+	if len(authed) != 1 || authed[0] != "user-7" {
+		t.Fatalf("authed %v", authed)
+	}
+}
+
+// authListener 在OnConnected中读取鉴权数据
+type authListener struct {
+	*testListener
+	authed *[]any
+}
+
+func (l *authListener) OnConnected(conn Channel) {
+	*l.authed = append(*l.authed, WsAuthData.Value(conn))
+	l.testListener.OnConnected(conn)
+}
+
+func TestWSServerOrigin(t *testing.T) {
+	addr := freeAddr(t)
+	server := NewWSServer(addr, &testListener{}, WithWsUpgrader(WsUpgrader{
+		AllowedOrigins: []string{"https://game.example.com", "*.test.com"},
+	}))
+	go server.Listen()
+	defer server.Close()
+	dialRetry(t, addr).Close()
+
+	cases := map[string]bool{
+		"https://game.example.com": true,
+		"http://game.example.com":  false,
+		"https://a.test.com":       true,
+		"https://evil.com":         false,
+		"":                         true,
+	}
+	for origin, ok := range cases {
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		c, resp, err := websocket.DefaultDialer.Dial("ws://"+addr+"/", header)
+		if ok != (err == nil) {
+			t.Fatalf("origin %q err %v", origin, err)
+		}
+		if err == nil {
+			_ = c.Close()
+		} else if resp == nil || resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("origin %q resp %v", origin, resp)
+		}
+	}
+}
+
+func TestWSServerCompressionAndLimit(t *testing.T) {
+	l := &testListener{}
+	l.onData = func(conn Channel, msg *buffer.ByteBuf) {
+		conn.WriteAndFlush(append([]byte(nil), msg.Bytes()...))
+	}
+	addr := freeAddr(t)
+	server := NewWSServer(addr, l, WithWsUpgrader(WsUpgrader{
+		ReadLimit:         1024,
+		Subprotocols:      []string{"game.v2", "game.v1"},
+		EnableCompression: true,
+		CompressionLevel:  1,
+		CompressThreshold: 64,
+	}))
+	go server.Listen()
+	defer server.Close()
+	dialRetry(t, addr).Close()
+
+	dialer := websocket.Dialer{EnableCompression: true, Subprotocols: []string{"game.v1", "game.v2"}}
+	c, resp, err := dialer.Dial("ws://"+addr+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if !strings.Contains(resp.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate") || c.Subprotocol() != "game.v2" {
+		t.Fatalf("extensions %q subprotocol %q", resp.Header.Get("Sec-Websocket-Extensions"), c.Subprotocol())
+	}
+	big := strings.Repeat("a", 500)
+	for _, text := range []string{"small", big} {
+		_ = c.WriteMessage(websocket.BinaryMessage, []byte(text))
+		_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, b, err := c.ReadMessage()
+		if err != nil || string(b) != text {
+			t.Fatalf("echo %q err %v", b, err)
+		}
+	}
+	//超过ReadLimit断开（压缩时限制的是压缩后的长度，使用随机数据）
+	random := make([]byte, 2048)
+	_, _ = rand.Read(random)
+	_ = c.WriteMessage(websocket.BinaryMessage, random)
+	waitFor(t, func() bool { return l.disconnectedCount() == 1 })
+}
+
+func TestWSServerMount(t *testing.T) {
+	game, chat := &testListener{}, &testListener{}
+	var zones []string
+	game.onData = func(conn Channel, msg *buffer.ByteBuf) {
+		conn.WriteAndFlush([]byte("game:" + WsParams.Value(conn).ByName("zone")))
+	}
+	chat.onData = func(conn Channel, msg *buffer.ByteBuf) {
+		conn.WriteAndFlush([]byte("chat"))
+	}
+	gameServer := NewWSServer("", &shutdownListener{testListener: game, zones: &zones})
+	chatServer := NewWSServer("", chat)
+	router := grouter.New()
+	gameServer.Mount(router, "/ws/game/:zone")
+	chatServer.Mount(router, "/ws/chat")
+	router.GET("/gm/ping", func(w http.ResponseWriter, r *http.Request, _ grouter.Params) {
+		_, _ = io.WriteString(w, "pong")
+	})
+	addr := freeAddr(t)
+	server := &http.Server{Addr: addr, Handler: router}
+	go func() { _ = server.ListenAndServe() }()
+	dialRetry(t, addr).Close()
+
+	resp, err := http.Get("http://" + addr + "/gm/ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != "pong" {
+		t.Fatalf("gm %q", body)
+	}
+	for path, reply := range map[string]string{"/ws/game/3": "game:3", "/ws/chat": "chat"} {
+		c, _, err := websocket.DefaultDialer.Dial("ws://"+addr+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		_ = c.WriteMessage(websocket.BinaryMessage, []byte("hi"))
+		_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, b, err := c.ReadMessage()
+		if err != nil || string(b) != reply {
+			t.Fatalf("%s reply %q err %v", path, b, err)
+		}
+	}
+	if gameServer.Registry().Count() != 1 || chatServer.Registry().Count() != 1 {
+		t.Fatalf("game %d chat %d", gameServer.Registry().Count(), chatServer.Registry().Count())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err = ShutdownHTTP(ctx, server, gameServer, chatServer); err != nil {
+		t.Fatal(err)
+	}
+	if game.disconnectedCount() != 1 || chat.disconnectedCount() != 1 || len(zones) != 1 || zones[0] != "3" {
+		t.Fatalf("game %d chat %d zones %v", game.disconnectedCount(), chat.disconnectedCount(), zones)
+	}
+}
+
+// shutdownListener 在OnShutdown中读取路径参数
+type shutdownListener struct {
+	*testListener
+	zones *[]string
+}
+
+func (l *shutdownListener) OnShutdown(conn Channel) {
+	*l.zones = append(*l.zones, WsParams.Value(conn).ByName("zone"))
+}
+
+func TestWSServerShutdownTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	l := &testListener{}
+	addr := freeAddr(t)
+	server := NewWSServer(addr, l, WithWsUpgrader(WsUpgrader{
+		Authorize: func(r *http.Request) (any, error) {
+			if r.URL.Query().Get("block") != "" {
+				<-block
+			}
+			return nil, nil
+		},
+	}))
+	go server.Listen()
+	dialRetry(t, addr).Close()
+
+	c, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	waitFor(t, func() bool { return l.connectedCount() == 1 })
+	//未完成的http请求使http服务器关闭超时
+	go func() { _, _, _ = websocket.DefaultDialer.Dial("ws://"+addr+"/?block=1", nil) }()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err = server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("shutdown err %v", err)
+	}
+	//http服务器关闭超时后websocket连接同样断开
+	waitFor(t, func() bool { return l.disconnectedCount() == 1 })
+}
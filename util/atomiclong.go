@@ -20,3 +20,7 @@ func (a *AtomicLong) IncrementAndGet() int64 {
 func (a *AtomicLong) DecrementAndGet() int64 {
 	return atomic.AddInt64(&a.value, -1)
 }
+
+func (a *AtomicLong) AddAndGet(delta int64) int64 {
+	return atomic.AddInt64(&a.value, delta)
+}